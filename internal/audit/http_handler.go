@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/middleware/validation"
+	"go-multiple-query/internal/utilities"
+	"math"
+	"strconv"
+	"time"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type httpHandler struct {
+	auditService domain.AuditService
+}
+
+// NewHTTPHandler creates a new instance of HTTPHandler.
+func NewHTTPHandler(r fiber.Router, auditService domain.AuditService) {
+	handler := &httpHandler{
+		auditService: auditService,
+	}
+
+	r.Get("/", validation.NewQuery[domain.PageQuery](), handler.Find)
+}
+
+// Find handles the list audit entries request.
+func (h *httpHandler) Find(c *fiber.Ctx) error {
+	filter, errs := parseAuditFilter(c, validation.Translator(c))
+	if len(errs) > 0 {
+		return domain.Invalid("Invalid audit filter", errs...)
+	}
+
+	page, err := h.auditService.Find(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+
+	maxPage := int(math.Ceil(float64(page.Total) / float64(filter.Size)))
+	c.Set("X-Total-Count", strconv.Itoa(int(page.Total)))
+	c.Set("X-Max-Page", strconv.Itoa(maxPage))
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Audit entries have been fetched successfully",
+		Data:    page.Entries,
+	})
+}
+
+// parseAuditFilter reads the audit filter from the query string, with the
+// page and size validated by validation.NewQuery. The time range is given as
+// RFC 3339 timestamps, from inclusive and to exclusive. Rejected parameters
+// are described with the messages of trans.
+func parseAuditFilter(c *fiber.Ctx, trans ut.Translator) (domain.AuditFilter, []domain.FieldError) {
+	var errs []domain.FieldError
+	filter := domain.AuditFilter{Actor: c.Query("actor")}
+	filter.Page, filter.Size = utilities.ExtractStructFromValidator[domain.PageQuery](c).Resolve(1, 10)
+
+	if voucherId := c.Query("voucher_id"); voucherId != "" {
+		id, err := primitive.ObjectIDFromHex(voucherId)
+		if err != nil {
+			errs = append(errs, validation.FieldError(trans, "voucher_id", "object_id"))
+		} else {
+			filter.VoucherId = &id
+		}
+	}
+
+	parseTime := func(name string) *time.Time {
+		value := c.Query(name)
+		if value == "" {
+			return nil
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			errs = append(errs, validation.FieldError(trans, name, "rfc3339"))
+			return nil
+		}
+		return &parsed
+	}
+	filter.From, filter.To = parseTime("from"), parseTime("to")
+
+	return filter, errs
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/middleware/validation"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	var filter domain.AuditFilter
+	var errs []domain.FieldError
+
+	app := fiber.New()
+	app.Get("/", validation.NewQuery[domain.PageQuery](), func(c *fiber.Ctx) error {
+		filter, errs = parseAuditFilter(c, validation.TranslatorFor("en"))
+		return nil
+	})
+
+	_, err := app.Test(httptest.NewRequest("GET", "/?actor=finance&voucher_id=65a1b2c3d4e5f60718293a4b&from=2026-01-01T00:00:00Z&size=20", nil))
+	assert.NoError(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, "finance", filter.Actor)
+	assert.Equal(t, "65a1b2c3d4e5f60718293a4b", filter.VoucherId.Hex())
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), *filter.From)
+	assert.Nil(t, filter.To)
+	assert.Equal(t, 1, filter.Page)
+	assert.Equal(t, 20, filter.Size)
+
+	_, err = app.Test(httptest.NewRequest("GET", "/?voucher_id=abc&to=yesterday", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.FieldError{
+		{Field: "voucher_id", Rule: "object_id", Code: "invalid", Message: "voucher_id must be a voucher ID"},
+		{Field: "to", Rule: "rfc3339", Code: "invalid", Message: "to must be an RFC 3339 timestamp"},
+	}, errs)
+
+	for _, target := range []string{"/?page=0", "/?size=abc", "/?size=500"} {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, target)
+	}
+}
+
+func TestBuildAuditQuery(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := buildAuditQuery(domain.AuditFilter{Actor: "finance", From: &from})
+	assert.Equal(t, "finance", query["actor"])
+	assert.Equal(t, bson.M{"$gte": from}, query["created_at"])
+}
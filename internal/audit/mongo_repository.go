@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"go-multiple-query/internal/domain"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongodbRepository struct {
+	db *mongo.Database
+}
+
+// StoreMany implements domain.AuditRepository. The entries are inserted in
+// a single write.
+func (m *mongodbRepository) StoreMany(ctx context.Context, entries []*domain.AuditEntry) error {
+	coll := m.db.Collection("audit_log")
+
+	docs := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		docs = append(docs, entry)
+	}
+
+	_, err := coll.InsertMany(ctx, docs)
+	return err
+}
+
+// Find implements domain.AuditRepository.
+func (m *mongodbRepository) Find(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error) {
+	coll := m.db.Collection("audit_log")
+	query := buildAuditQuery(filter)
+
+	total, err := coll.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((filter.Page - 1) * filter.Size)).
+		SetLimit(int64(filter.Size))
+
+	cursor, err := coll.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []*domain.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return &domain.AuditPage{Entries: entries, Total: total}, nil
+}
+
+// buildAuditQuery translates the audit filter into a mongo query.
+func buildAuditQuery(filter domain.AuditFilter) bson.M {
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.VoucherId != nil {
+		query["voucher_id"] = *filter.VoucherId
+	}
+
+	createdAt := bson.M{}
+	if filter.From != nil {
+		createdAt["$gte"] = *filter.From
+	}
+	if filter.To != nil {
+		createdAt["$lt"] = *filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	return query
+}
+
+// EnsureIndexes creates the indexes used to filter the audit log by voucher
+// and by actor.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("audit_log").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "voucher_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("voucher_id_created_at"),
+		},
+		{
+			Keys:    bson.D{{Key: "actor", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("actor_created_at"),
+		},
+	})
+	return err
+}
+
+// NewMongoRepository creates a new instance of AuditRepository.
+func NewMongoRepository(db *mongo.Database) domain.AuditRepository {
+	return &mongodbRepository{
+		db: db,
+	}
+}
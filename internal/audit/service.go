@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"context"
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/utilities"
+)
+
+type auditService struct {
+	auditRepo domain.AuditRepository
+}
+
+// RecordMany implements domain.AuditService.
+func (a *auditService) RecordMany(ctx context.Context, entries []*domain.AuditEntry) error {
+	return utilities.DatabaseError(a.auditRepo.StoreMany(ctx, entries))
+}
+
+// Find implements domain.AuditService.
+func (a *auditService) Find(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error) {
+	page, err := a.auditRepo.Find(ctx, filter)
+	if err != nil {
+		return &domain.AuditPage{}, utilities.DatabaseError(err)
+	}
+
+	return page, err
+}
+
+// NewAuditService creates a new instance of AuditService.
+func NewAuditService(auditRepo domain.AuditRepository) domain.AuditService {
+	return &auditService{
+		auditRepo: auditRepo,
+	}
+}
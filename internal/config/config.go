@@ -1,14 +1,27 @@
 package config
 
+import "time"
+
 type Config struct {
-	Host          string   `env:"HOST" envDefault:"localhost"`
-	Port          int      `env:"PORT" envDefault:"8080"`
-	ProxyHeader   string   `env:"PROXY_HEADER" envDefault:"X-Forwarded-For"`
-	LogFields     []string `env:"LOG_FIELDS" envSeparator:","`
-	IsDevelopment bool     `env:"IS_DEVELOPMENT" envDefault:"true"`
-	MongoDb       MongoDb
+	Host           string        `env:"HOST" envDefault:"localhost"`
+	Port           int           `env:"PORT" envDefault:"8080"`
+	ProxyHeader    string        `env:"PROXY_HEADER" envDefault:"X-Forwarded-For"`
+	LogFields      []string      `env:"LOG_FIELDS" envSeparator:","`
+	IsDevelopment  bool          `env:"IS_DEVELOPMENT" envDefault:"true"`
+	CursorSecret   string        `env:"CURSOR_SECRET"`
+	QueryTimeout   time.Duration `env:"QUERY_TIMEOUT" envDefault:"10s"`
+	ExportTimeout  time.Duration `env:"EXPORT_TIMEOUT" envDefault:"10m"`
+	ProblemDetails bool          `env:"PROBLEM_DETAILS" envDefault:"false"`
+	MongoDb        MongoDb
+	Reservation    Reservation
 }
 
 type MongoDb struct {
-	URI string `env:"MONGODB_URI,notEmpty"`
+	URI                string `env:"MONGODB_URI,notEmpty"`
+	UniqueSkuPerVendor bool   `env:"MONGODB_UNIQUE_SKU_PER_VENDOR" envDefault:"false"`
+}
+
+type Reservation struct {
+	TTL           time.Duration `env:"RESERVATION_TTL" envDefault:"15m"`
+	SweepInterval time.Duration `env:"RESERVATION_SWEEP_INTERVAL" envDefault:"1m"`
 }
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a single mutating API call. Changes holds the voucher
+// fields that differ before and after the call, keyed by json name.
+type AuditEntry struct {
+	Id        primitive.ObjectID            `json:"id" bson:"_id,omitempty"`
+	Actor     string                        `json:"actor" bson:"actor"`
+	RequestId string                        `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Method    string                        `json:"method" bson:"method"`
+	Route     string                        `json:"route" bson:"route"`
+	Path      string                        `json:"path" bson:"path"`
+	Status    int                           `json:"status" bson:"status"`
+	VoucherId *primitive.ObjectID           `json:"voucher_id,omitempty" bson:"voucher_id,omitempty"`
+	Changes   map[string]VoucherFieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	CreatedAt time.Time                     `json:"created_at" bson:"created_at"`
+}
+
+// AuditFilter narrows down the audit entries to list. Zero values match
+// everything.
+type AuditFilter struct {
+	Actor     string
+	VoucherId *primitive.ObjectID
+	From      *time.Time
+	To        *time.Time
+	Page      int
+	Size      int
+}
+
+// AuditPage is a page of audit entries, newest first.
+type AuditPage struct {
+	Entries []*AuditEntry
+	Total   int64
+}
+
+type AuditRepository interface {
+	StoreMany(ctx context.Context, entries []*AuditEntry) error
+	Find(ctx context.Context, filter AuditFilter) (*AuditPage, error)
+}
+
+type AuditService interface {
+	RecordMany(ctx context.Context, entries []*AuditEntry) error
+	Find(ctx context.Context, filter AuditFilter) (*AuditPage, error)
+}
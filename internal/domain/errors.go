@@ -0,0 +1,63 @@
+package domain
+
+import "errors"
+
+// Error kinds. Every *Error wraps its kind, so errors.Is(err, ErrNotFound)
+// reports whether err is a not found error.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrInvalid     = errors.New("invalid")
+	ErrUnavailable = errors.New("unavailable")
+	ErrTimeout     = errors.New("timeout")
+)
+
+// Error is an error of a known kind. Message and Details are returned to
+// clients, the cause in Err is only meant for logs.
+type Error struct {
+	Kind    error
+	Message string
+	Details []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// NotFound returns an error for a resource that does not exist.
+func NotFound(message string) *Error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+// Conflict returns an error for a request that clashes with the stored
+// state, caused by err.
+func Conflict(message string, err error) *Error {
+	return &Error{Kind: ErrConflict, Message: message, Err: err}
+}
+
+// Invalid returns an error for a malformed request, with details on every
+// rejected part of it.
+func Invalid(message string, details ...FieldError) *Error {
+	return &Error{Kind: ErrInvalid, Message: message, Details: details}
+}
+
+// Unavailable returns an error for a dependency that cannot be reached.
+func Unavailable(err error) *Error {
+	return &Error{Kind: ErrUnavailable, Message: "Service is temporarily unavailable", Err: err}
+}
+
+// Timeout returns an error for a request that ran out of time.
+func Timeout(err error) *Error {
+	return &Error{Kind: ErrTimeout, Message: "Request timed out", Err: err}
+}
@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VoucherFilter is a parsed, backend-neutral voucher query. Repositories
+// translate it into their own query language. Fields lists the json fields to
+// return, all of them when empty.
+type VoucherFilter struct {
+	Conditions []FilterCondition
+	Search     string
+	Sort       []SortField
+	Fields     []string
+	Page       int
+	Size       int
+	Cursor     string
+	WithTotal  bool
+
+	// IncludeDeleted also matches archived vouchers.
+	IncludeDeleted bool
+}
+
+// PageQuery holds the pagination query parameters of a listing request, kept
+// as strings so values that are not numbers are reported by the validation
+// rules. An empty field is a parameter missing from the query string.
+type PageQuery struct {
+	Page string `query:"page" validate:"omitempty,number,page"`
+	Size string `query:"size" validate:"omitempty,number,page_size"`
+}
+
+// Resolve returns the page and size of a validated query, the given defaults
+// for the missing ones. A nil query has neither.
+func (q *PageQuery) Resolve(page, size int) (int, int) {
+	if q == nil {
+		return page, size
+	}
+	if parsed, err := strconv.Atoi(q.Page); err == nil {
+		page = parsed
+	}
+	if parsed, err := strconv.Atoi(q.Size); err == nil {
+		size = parsed
+	}
+	return page, size
+}
+
+// FilterCondition restricts a voucher field with an operator. Value holds an
+// int or string matching the field type, or a slice of them for list operators.
+type FilterCondition struct {
+	Field    string
+	Operator FilterOperator
+	Value    interface{}
+}
+
+// SortField is a single key of a multi-key sort.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// FieldType is the type of the values stored in a voucher field.
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldInt
+)
+
+// FilterField describes a voucher field that can be filtered and sorted on.
+// Multi fields accept repeated or comma separated values for equality.
+type FilterField struct {
+	Type  FieldType
+	Multi bool
+}
+
+// VoucherFilterFields lists the filterable voucher fields keyed by their
+// query parameter name.
+var VoucherFilterFields = map[string]FilterField{
+	"brand_code":        {Type: FieldString, Multi: true},
+	"sku":               {Type: FieldString, Multi: true},
+	"sku_name":          {Type: FieldString},
+	"nominal":           {Type: FieldInt},
+	"distributor_price": {Type: FieldInt},
+	"product_status":    {Type: FieldString},
+	"order_destination": {Type: FieldString},
+	"stock":             {Type: FieldInt},
+	"vendor":            {Type: FieldString, Multi: true},
+}
+
+// FilterOperator is a comparison applied to a voucher filter field, written as
+// field[operator] in the query string.
+type FilterOperator string
+
+const (
+	FilterEq       FilterOperator = "eq"
+	FilterGte      FilterOperator = "gte"
+	FilterLte      FilterOperator = "lte"
+	FilterGt       FilterOperator = "gt"
+	FilterLt       FilterOperator = "lt"
+	FilterNe       FilterOperator = "ne"
+	FilterIn       FilterOperator = "in"
+	FilterNotIn    FilterOperator = "not_in"
+	FilterContains FilterOperator = "contains"
+	FilterPrefix   FilterOperator = "prefix"
+)
+
+// IsValid reports whether the operator is supported.
+func (o FilterOperator) IsValid() bool {
+	switch o {
+	case FilterEq, FilterGte, FilterLte, FilterGt, FilterLt, FilterNe, FilterIn, FilterNotIn, FilterContains, FilterPrefix:
+		return true
+	}
+	return false
+}
+
+// IsRange reports whether the operator compares by order and therefore only
+// applies to numeric fields.
+func (o FilterOperator) IsRange() bool {
+	return o == FilterGte || o == FilterLte || o == FilterGt || o == FilterLt
+}
+
+// IsList reports whether the operator takes a comma separated list of values.
+func (o FilterOperator) IsList() bool {
+	return o == FilterIn || o == FilterNotIn
+}
+
+// IsText reports whether the operator performs a case-insensitive text match
+// and therefore only applies to string fields.
+func (o FilterOperator) IsText() bool {
+	return o == FilterContains || o == FilterPrefix
+}
+
+// InvalidFilterParam describes a single rejected filter parameter by the
+// rule it failed, named like the validation rules, and the parameter of the
+// rule, such as the unknown field of order_by.
+type InvalidFilterParam struct {
+	Name  string
+	Rule  string
+	Param string
+}
+
+// InvalidFilterError is returned when filter parameters reference unknown
+// fields or operators, or cannot be coerced to the type of their field.
+type InvalidFilterError struct {
+	Params []InvalidFilterParam
+}
+
+func (e *InvalidFilterError) Error() string {
+	var names []string
+	for _, param := range e.Params {
+		names = append(names, param.Name)
+	}
+	return "invalid filter parameters: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actors used when a change is not made by an identified client.
+const (
+	AnonymousActor = "anonymous"
+	SystemActor    = "system"
+)
+
+// Actor identifies who made a change and the request it was made in.
+type Actor struct {
+	Name      string
+	RequestId string
+}
+
+// VoucherHistoryFields are the voucher fields whose changes are recorded.
+var VoucherHistoryFields = []string{"distributor_price", "stock", "product_status"}
+
+// VoucherHistory is a single recorded change of a voucher field. Old is nil
+// for the values a voucher was created with.
+type VoucherHistory struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VoucherId primitive.ObjectID `json:"voucher_id" bson:"voucher_id"`
+	Field     string             `json:"field" bson:"field"`
+	Old       interface{}        `json:"old" bson:"old"`
+	New       interface{}        `json:"new" bson:"new"`
+	Actor     string             `json:"actor" bson:"actor"`
+	RequestId string             `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	ChangedAt time.Time          `json:"changed_at" bson:"changed_at"`
+}
+
+// VoucherHistoryPage is a page of voucher history, newest change first.
+type VoucherHistoryPage struct {
+	History []*VoucherHistory
+	Total   int64
+}
@@ -0,0 +1,93 @@
+package domain
+
+// ProductStatus is the lifecycle state of a voucher.
+type ProductStatus string
+
+const (
+	ProductStatusDraft        ProductStatus = "draft"
+	ProductStatusAvailable    ProductStatus = "available"
+	ProductStatusOutOfStock   ProductStatus = "out_of_stock"
+	ProductStatusDiscontinued ProductStatus = "discontinued"
+)
+
+// productStatusTransitions lists the statuses a voucher may move to from each
+// status. Discontinued vouchers stay discontinued.
+var productStatusTransitions = map[ProductStatus][]ProductStatus{
+	ProductStatusDraft:        {ProductStatusAvailable, ProductStatusOutOfStock, ProductStatusDiscontinued},
+	ProductStatusAvailable:    {ProductStatusOutOfStock, ProductStatusDiscontinued},
+	ProductStatusOutOfStock:   {ProductStatusAvailable, ProductStatusDiscontinued},
+	ProductStatusDiscontinued: {},
+}
+
+// IsValid reports whether s is a known product status.
+func (s ProductStatus) IsValid() bool {
+	_, ok := productStatusTransitions[s]
+	return ok
+}
+
+// ProductStatuses returns every known product status.
+func ProductStatuses() []ProductStatus {
+	return []ProductStatus{ProductStatusDraft, ProductStatusAvailable, ProductStatusOutOfStock, ProductStatusDiscontinued}
+}
+
+// CanTransitionTo reports whether a voucher may move from s to next. Keeping
+// the same status is always allowed. Vouchers stored with a status that is no
+// longer known may move to any known status, so they can be brought back into
+// the lifecycle.
+func (s ProductStatus) CanTransitionTo(next ProductStatus) bool {
+	if s == next {
+		return true
+	}
+	if !s.IsValid() {
+		return next.IsValid()
+	}
+	for _, allowed := range productStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionsFrom returns the known statuses a voucher may move to s from, s
+// included. Unknown statuses may move to any known status, see
+// CanTransitionTo.
+func (s ProductStatus) TransitionsFrom() []ProductStatus {
+	from := []ProductStatus{s}
+	for status, next := range productStatusTransitions {
+		for _, allowed := range next {
+			if allowed == s {
+				from = append(from, status)
+			}
+		}
+	}
+	return from
+}
+
+// ForStock returns the status a voucher in status s has with the given
+// stock. Available vouchers run out of stock at zero and become available
+// again once restocked, other statuses are kept.
+func (s ProductStatus) ForStock(stock int) ProductStatus {
+	switch {
+	case s == ProductStatusAvailable && stock <= 0:
+		return ProductStatusOutOfStock
+	case s == ProductStatusOutOfStock && stock > 0:
+		return ProductStatusAvailable
+	}
+	return s
+}
+
+type ChangeProductStatusRequest struct {
+	Status ProductStatus `json:"status" validate:"required,product_status"`
+}
+
+// InvalidStatusTransitionError is returned when a voucher cannot move from
+// its current product status to the requested one.
+type InvalidStatusTransitionError struct {
+	From ProductStatus
+	To   ProductStatus
+}
+
+func (e *InvalidStatusTransitionError) Error() string {
+	return "product status cannot change from " + string(e.From) + " to " + string(e.To)
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductStatus_CanTransitionTo(t *testing.T) {
+	assert.True(t, ProductStatusDraft.CanTransitionTo(ProductStatusAvailable))
+	assert.True(t, ProductStatusAvailable.CanTransitionTo(ProductStatusAvailable))
+	assert.True(t, ProductStatusOutOfStock.CanTransitionTo(ProductStatusAvailable))
+	assert.False(t, ProductStatusAvailable.CanTransitionTo(ProductStatusDraft))
+	assert.False(t, ProductStatusDiscontinued.CanTransitionTo(ProductStatusAvailable))
+	assert.False(t, ProductStatusDraft.CanTransitionTo("active"))
+}
+
+func TestProductStatus_CanTransitionTo_Legacy(t *testing.T) {
+	legacy := ProductStatus("active")
+	assert.True(t, legacy.CanTransitionTo(legacy))
+	assert.True(t, legacy.CanTransitionTo(ProductStatusAvailable))
+	assert.True(t, legacy.CanTransitionTo(ProductStatusDiscontinued))
+	assert.False(t, legacy.CanTransitionTo("unavailable"))
+}
+
+func TestProductStatus_TransitionsFrom(t *testing.T) {
+	assert.ElementsMatch(t, []ProductStatus{ProductStatusAvailable, ProductStatusDraft, ProductStatusOutOfStock}, ProductStatusAvailable.TransitionsFrom())
+	assert.Equal(t, []ProductStatus{ProductStatusDraft}, ProductStatusDraft.TransitionsFrom())
+}
+
+func TestProductStatus_ForStock(t *testing.T) {
+	assert.Equal(t, ProductStatusOutOfStock, ProductStatusAvailable.ForStock(0))
+	assert.Equal(t, ProductStatusAvailable, ProductStatusAvailable.ForStock(5))
+	assert.Equal(t, ProductStatusAvailable, ProductStatusOutOfStock.ForStock(5))
+	assert.Equal(t, ProductStatusDraft, ProductStatusDraft.ForStock(0))
+	assert.Equal(t, ProductStatusDiscontinued, ProductStatusDiscontinued.ForStock(5))
+}
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reservation statuses.
+const (
+	ReservationActive   = "active"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+)
+
+// Reservation holds stock taken from a voucher until it is released or
+// expires. Partial releases lower the quantity of an active reservation.
+type Reservation struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	VoucherId  primitive.ObjectID `json:"voucher_id" bson:"voucher_id"`
+	Quantity   int                `json:"quantity" bson:"quantity"`
+	Status     string             `json:"status" bson:"status"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time          `json:"expires_at" bson:"expires_at"`
+	ReleasedAt *time.Time         `json:"released_at,omitempty" bson:"released_at,omitempty"`
+}
+
+type ReserveStockRequest struct {
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+// ReleaseStockRequest releases a reservation, or only part of it when a
+// quantity is given.
+type ReleaseStockRequest struct {
+	ReservationId string `json:"reservation_id" validate:"required"`
+	Quantity      int    `json:"quantity" validate:"omitempty,gt=0"`
+}
+
+var (
+	ErrReservationNotFound       = errors.New("reservation not found")
+	ErrReservationClosed         = errors.New("reservation has already been released or has expired")
+	ErrReleaseExceedsReservation = errors.New("release quantity exceeds the reserved quantity")
+)
+
+// InsufficientStockError is returned when a voucher has less stock than a
+// reservation asks for.
+type InsufficientStockError struct {
+	Requested int
+	Available int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock: requested %d, available %d", e.Requested, e.Available)
+}
@@ -5,5 +5,16 @@ type Response struct {
 	Status  string      `json:"status"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
-	Errors  []string    `json:"errors,omitempty"`
+	Errors  interface{} `json:"errors,omitempty"`
+}
+
+// FieldError describes a request field that failed a validation rule. Field
+// is the json name, Code a stable identifier of the failure and Message a
+// description in the language of the client.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
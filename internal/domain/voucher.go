@@ -1,6 +1,13 @@
 package domain
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Voucher struct {
 	Id               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
@@ -9,49 +16,210 @@ type Voucher struct {
 	SkuName          string             `json:"sku_name" bson:"sku_name" query:"sku_name"`
 	Nominal          int                `json:"nominal" bson:"nominal" query:"nominal"`
 	DistributorPrice int                `json:"distributor_price" bson:"distributor_price" query:"distributor_price"`
-	ProductStatus    string             `json:"product_status" bson:"product_status" query:"product_status"`
+	ProductStatus    ProductStatus      `json:"product_status" bson:"product_status" query:"product_status"`
 	OrderDestination string             `json:"order_destination" bson:"order_destination" query:"order_destination"`
 	Stock            int                `json:"stock" bson:"stock" query:"stock"`
 	Vendor           string             `json:"vendor" bson:"vendor" query:"vendor"`
+	DeletedAt        *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 type VoucherRepository interface {
-	FindByID(id primitive.ObjectID) (*Voucher, error)
-	Store(voucher *Voucher) (*Voucher, error)
-	Count(filter VoucherFilter) (int64, error)
-	FindWithFilter(filter VoucherFilter) ([]*Voucher, int, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Voucher, error)
+	FindBySkus(ctx context.Context, skus []string) ([]*Voucher, error)
+	Store(ctx context.Context, actor Actor, voucher *Voucher) (*Voucher, error)
+	StoreMany(ctx context.Context, actor Actor, vouchers []*Voucher, ordered bool) ([]error, error)
+	Update(ctx context.Context, actor Actor, update *VoucherUpdate) (*Voucher, error)
+	UpdateMany(ctx context.Context, actor Actor, updates []*VoucherUpdate) ([]error, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Restore(ctx context.Context, id primitive.ObjectID) (*Voucher, error)
+	FindWithFilter(ctx context.Context, filter VoucherFilter) (*VoucherPage, error)
+	Export(ctx context.Context, filter VoucherFilter, each func(*Voucher) error) error
+	Aggregate(ctx context.Context, filter VoucherFilter, groupBy string) ([]*VoucherStats, error)
+	Reserve(ctx context.Context, actor Actor, id primitive.ObjectID, quantity int, expiresAt time.Time) (*Reservation, error)
+	Release(ctx context.Context, actor Actor, id, reservationId primitive.ObjectID, quantity int) (*Reservation, error)
+	ReleaseExpired(ctx context.Context, actor Actor, now time.Time) (int, error)
+	UpdateStatus(ctx context.Context, actor Actor, id primitive.ObjectID, status ProductStatus) (*Voucher, error)
+	FindHistory(ctx context.Context, id primitive.ObjectID, page, size int) (*VoucherHistoryPage, error)
 }
 
 type VoucherService interface {
-	Store(voucher *Voucher) (*Voucher, error)
-	Count(filter VoucherFilter) (int64, error)
-	FindWithFilter(filter VoucherFilter) ([]*Voucher, int, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Voucher, error)
+	Store(ctx context.Context, actor Actor, voucher *Voucher) (*Voucher, error)
+	StoreMany(ctx context.Context, actor Actor, vouchers []*Voucher, ordered bool) ([]error, error)
+	Import(ctx context.Context, actor Actor, rows []*VoucherImportRow, dryRun bool) (*VoucherImportReport, error)
+	Update(ctx context.Context, actor Actor, id primitive.ObjectID, voucher *Voucher) (*Voucher, error)
+	Patch(ctx context.Context, actor Actor, id primitive.ObjectID, patch map[string]json.RawMessage) (*Voucher, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	Restore(ctx context.Context, id primitive.ObjectID) (*Voucher, error)
+	FindWithFilter(ctx context.Context, filter VoucherFilter) (*VoucherPage, error)
+	Export(ctx context.Context, filter VoucherFilter, each func(*Voucher) error) error
+	Aggregate(ctx context.Context, filter VoucherFilter, groupBy string) ([]*VoucherStats, error)
+	Reserve(ctx context.Context, actor Actor, id primitive.ObjectID, quantity int) (*Reservation, error)
+	Release(ctx context.Context, actor Actor, id, reservationId primitive.ObjectID, quantity int) (*Reservation, error)
+	ReleaseExpiredReservations(ctx context.Context) (int, error)
+	ChangeStatus(ctx context.Context, actor Actor, id primitive.ObjectID, status ProductStatus) (*Voucher, error)
+	FindHistory(ctx context.Context, id primitive.ObjectID, page, size int) (*VoucherHistoryPage, error)
 }
 
 type StoreVoucherRequest struct {
-	BrandCode        string `json:"brand_code" validate:"required"`
-	Sku              string `json:"sku" validate:"required"`
-	SkuName          string `json:"sku_name" validate:"required"`
-	Nominal          int    `json:"nominal" validate:"required"`
-	DistributorPrice int    `json:"distributor_price" validate:"required"`
-	ProductStatus    string `json:"product_status" validate:"required"`
-	OrderDestination string `json:"order_destination" validate:"required"`
-	Stock            int    `json:"stock" validate:"required"`
-	Vendor           string `json:"vendor" validate:"required"`
-}
-
-type VoucherFilter struct {
-	BrandCode        string `query:"brand_code"`
-	Sku              string `query:"sku"`
-	SkuName          string `query:"sku_name"`
-	Nominal          string `query:"nominal"`
-	DistributorPrice string `query:"distributor_price"`
-	ProductStatus    string `query:"product_status"`
-	OrderDestination string `query:"order_destination"`
-	Stock            string `query:"stock"`
-	Vendor           string `query:"vendor"`
-	OrderBy          string `query:"order_by"`
-	SortOrder        string `query:"sort_order"`
-	Page             string `query:"page"`
-	Size             string `query:"size"`
+	BrandCode        string        `json:"brand_code" validate:"required"`
+	Sku              string        `json:"sku" validate:"required"`
+	SkuName          string        `json:"sku_name" validate:"required"`
+	Nominal          int           `json:"nominal" validate:"required"`
+	DistributorPrice int           `json:"distributor_price" validate:"required"`
+	ProductStatus    ProductStatus `json:"product_status" validate:"required,product_status"`
+	OrderDestination string        `json:"order_destination" validate:"required"`
+	Stock            int           `json:"stock" validate:"gte=0"`
+	Vendor           string        `json:"vendor" validate:"required"`
+}
+
+// VoucherUpdate sets some fields of a stored voucher, leaving the others as
+// they are stored. Fields lists the json names of the fields taken from
+// Voucher. IfStock, when set, only applies the update while the stored stock
+// still equals it. Before is the voucher as read before the update, which
+// UpdateMany records the history from.
+type VoucherUpdate struct {
+	Id      primitive.ObjectID
+	Voucher *Voucher
+	Fields  []string
+	IfStock *int
+	Before  *Voucher
+}
+
+// ErrVoucherChanged is returned when a voucher changed between being read
+// and being updated.
+var ErrVoucherChanged = errors.New("voucher changed while it was being updated")
+
+// ErrVoucherNotArchived is returned when restoring a voucher that is not
+// archived.
+var ErrVoucherNotArchived = errors.New("voucher is not archived")
+
+// VoucherPage is a page of vouchers along with what is needed to fetch the
+// next one, either by page number or by cursor. Total is nil when the total
+// was not requested.
+type VoucherPage struct {
+	Vouchers   []*Voucher
+	NextPage   int
+	NextCursor string
+	Total      *int64
+}
+
+// VoucherGroupFields lists the voucher fields vouchers can be aggregated by.
+var VoucherGroupFields = map[string]bool{
+	"brand_code":     true,
+	"vendor":         true,
+	"product_status": true,
+}
+
+// VoucherStats summarises the vouchers sharing the same value of the field
+// they were grouped by.
+type VoucherStats struct {
+	Group               string  `json:"group" bson:"_id"`
+	Count               int64   `json:"count" bson:"count"`
+	TotalStock          int64   `json:"total_stock" bson:"total_stock"`
+	MinNominal          int     `json:"min_nominal" bson:"min_nominal"`
+	MaxNominal          int     `json:"max_nominal" bson:"max_nominal"`
+	AvgNominal          float64 `json:"avg_nominal" bson:"avg_nominal"`
+	MinDistributorPrice int     `json:"min_distributor_price" bson:"min_distributor_price"`
+	MaxDistributorPrice int     `json:"max_distributor_price" bson:"max_distributor_price"`
+	AvgDistributorPrice float64 `json:"avg_distributor_price" bson:"avg_distributor_price"`
+}
+
+// DuplicateSkuError is returned when a voucher is written with a SKU that is
+// already taken. Archived is set when the SKU is held by an archived voucher,
+// which keeps its SKU until it is removed for good.
+type DuplicateSkuError struct {
+	Sku      string
+	Archived bool
+}
+
+func (e *DuplicateSkuError) Error() string {
+	if e.Archived {
+		return "voucher with sku " + e.Sku + " already exists as an archived voucher"
+	}
+	return "voucher with sku " + e.Sku + " already exists"
+}
+
+// ErrBulkSkipped is reported for the vouchers of an ordered bulk insert that
+// were not attempted because an earlier voucher failed.
+var ErrBulkSkipped = errors.New("not inserted because an earlier voucher failed")
+
+// Bulk voucher result statuses.
+const (
+	BulkStatusCreated   = "created"
+	BulkStatusInvalid   = "invalid"
+	BulkStatusDuplicate = "duplicate"
+	BulkStatusSkipped   = "skipped"
+	BulkStatusFailed    = "failed"
+)
+
+// BulkVoucherResult is the outcome of a single row of a bulk request. Errors
+// describe why the row failed, FieldErrors the fields that failed validation.
+// Changes lists the fields of a created voucher.
+type BulkVoucherResult struct {
+	Row         int                           `json:"row"`
+	Status      string                        `json:"status"`
+	Id          *primitive.ObjectID           `json:"id,omitempty"`
+	Sku         string                        `json:"sku,omitempty"`
+	Changes     map[string]VoucherFieldChange `json:"changes,omitempty"`
+	Errors      []string                      `json:"errors,omitempty"`
+	FieldErrors []FieldError                  `json:"field_errors,omitempty"`
+}
+
+// BulkVoucherReport summarises a bulk request row by row.
+type BulkVoucherReport struct {
+	Created int                  `json:"created"`
+	Failed  int                  `json:"failed"`
+	Results []*BulkVoucherResult `json:"results"`
+}
+
+// VoucherImportRow is a single row of a price list import. Fields lists the
+// json fields the row sets. Errors make the row invalid, CreateErrors only
+// apply when the row creates a new voucher rather than updating one.
+type VoucherImportRow struct {
+	Line         int
+	Request      StoreVoucherRequest
+	Fields       []string
+	Errors       []FieldError
+	CreateErrors []FieldError
+}
+
+// Voucher import row statuses.
+const (
+	ImportStatusNew       = "new"
+	ImportStatusChanged   = "changed"
+	ImportStatusUnchanged = "unchanged"
+	ImportStatusInvalid   = "invalid"
+	ImportStatusFailed    = "failed"
+)
+
+// VoucherFieldChange is the old and new value of a changed voucher field.
+type VoucherFieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// VoucherImportResult is the outcome of a single import row. Errors describe
+// why the row failed, FieldErrors the cells that failed validation.
+type VoucherImportResult struct {
+	Line        int                           `json:"line"`
+	Sku         string                        `json:"sku,omitempty"`
+	Status      string                        `json:"status"`
+	Id          *primitive.ObjectID           `json:"id,omitempty"`
+	Changes     map[string]VoucherFieldChange `json:"changes,omitempty"`
+	Errors      []string                      `json:"errors,omitempty"`
+	FieldErrors []FieldError                  `json:"field_errors,omitempty"`
+}
+
+// VoucherImportReport summarises a price list import. Nothing is written
+// when DryRun is set, the report then describes what would change.
+type VoucherImportReport struct {
+	DryRun    bool                   `json:"dry_run"`
+	Total     int                    `json:"total"`
+	New       int                    `json:"new"`
+	Changed   int                    `json:"changed"`
+	Unchanged int                    `json:"unchanged"`
+	Invalid   int                    `json:"invalid"`
+	Failed    int                    `json:"failed"`
+	Results   []*VoucherImportResult `json:"results"`
 }
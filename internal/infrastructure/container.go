@@ -1,10 +1,15 @@
 package infrastructure
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"go-multiple-query/internal/audit"
 	"go-multiple-query/internal/config"
 	"go-multiple-query/internal/domain"
 	"go-multiple-query/internal/voucher"
 	"go-multiple-query/pkg/xlogger"
+	"time"
 
 	"github.com/caarlos0/env/v10"
 
@@ -15,19 +20,77 @@ var (
 	cfg config.Config
 
 	voucherRepo domain.VoucherRepository
+	auditRepo   domain.AuditRepository
 
 	voucherService domain.VoucherService
+	auditService   domain.AuditService
 )
 
-func init() {
+// setup reads the configuration, connects to the database and wires the
+// repositories and services.
+func setup() {
 	if err := env.Parse(&cfg); err != nil {
 		panic(err)
 	}
 	xlogger.Setup(cfg)
 
+	if cfg.Reservation.TTL <= 0 || cfg.Reservation.SweepInterval <= 0 {
+		panic(errors.New("RESERVATION_TTL and RESERVATION_SWEEP_INTERVAL must be positive durations"))
+	}
+
 	db := mongodbSetup()
 
-	voucherRepo = voucher.NewMongoRepository(db)
+	// Vouchers stored before SKUs were unique may share one. The service
+	// still starts, without the unique index, until they are cleaned up
+	var indexErr *voucher.UniqueSkuIndexError
+	if err := voucher.EnsureIndexes(context.Background(), db, cfg.MongoDb.UniqueSkuPerVendor); errors.As(err, &indexErr) {
+		xlogger.Logger.Error().Strs("skus", indexErr.Skus).
+			Msg("Vouchers share SKUs, so duplicate SKUs are not rejected until they are cleaned up, see the README")
+	} else if err != nil {
+		panic(err)
+	}
+	if err := audit.EnsureIndexes(context.Background(), db); err != nil {
+		panic(err)
+	}
+
+	voucherRepo = voucher.NewMongoRepository(db, cursorSecret(), cfg.MongoDb.UniqueSkuPerVendor, xlogger.Logger)
+	auditRepo = audit.NewMongoRepository(db)
+
+	voucherService = voucher.NewVoucherService(voucherRepo, cfg.Reservation.TTL)
+	auditService = audit.NewAuditService(auditRepo)
+
+	go releaseExpiredReservations(cfg.Reservation.SweepInterval)
+}
+
+// releaseExpiredReservations returns the stock of abandoned reservations
+// once they expire, checking every interval.
+func releaseExpiredReservations(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.QueryTimeout)
+		released, err := voucherService.ReleaseExpiredReservations(ctx)
+		cancel()
+		if err != nil {
+			xlogger.Logger.Error().Err(err).Msg("Failed to release expired reservations")
+		}
+		if released > 0 {
+			xlogger.Logger.Info().Msgf("Released %d expired reservations", released)
+		}
+	}
+}
+
+// cursorSecret returns the key used to sign pagination cursors. Without a
+// configured secret a random one is generated, so cursors do not survive a
+// restart.
+func cursorSecret() []byte {
+	if cfg.CursorSecret != "" {
+		return []byte(cfg.CursorSecret)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	xlogger.Logger.Warn().Msg("CURSOR_SECRET is not set, pagination cursors will not survive a restart")
 
-	voucherService = voucher.NewVoucherService(voucherRepo)
+	return secret
 }
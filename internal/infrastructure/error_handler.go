@@ -1,25 +1,61 @@
 package infrastructure
 
 import (
+	"context"
 	"errors"
 	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/utilities"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
 )
 
-func defaultErrorHandler(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
-	msg := fiber.ErrInternalServerError.Error()
+// errorStatusCodes maps the domain error kinds to HTTP status codes.
+var errorStatusCodes = map[error]int{
+	domain.ErrNotFound:    fiber.StatusNotFound,
+	domain.ErrConflict:    fiber.StatusConflict,
+	domain.ErrInvalid:     fiber.StatusBadRequest,
+	domain.ErrUnavailable: fiber.StatusServiceUnavailable,
+	domain.ErrTimeout:     fiber.StatusGatewayTimeout,
+}
 
-	var e *fiber.Error
-	if errors.As(err, &e) {
-		code = e.Code
-		msg = e.Message
-	}
+// newErrorHandler returns the handler turning every error returned by a
+// route into an error response, see utilities.ErrorResponse. Domain errors
+// are reported with their own message, other errors as a bare internal
+// server error. Server errors are logged with their cause, which is never
+// returned to clients.
+func newErrorHandler(logger *zerolog.Logger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		code := fiber.StatusInternalServerError
+		msg := fiber.ErrInternalServerError.Error()
+		var details interface{}
+
+		var fiberErr *fiber.Error
+		var domainErr *domain.Error
+		switch {
+		case errors.As(err, &fiberErr):
+			code = fiberErr.Code
+			msg = fiberErr.Message
+		case errors.As(err, &domainErr):
+			if status, ok := errorStatusCodes[domainErr.Kind]; ok {
+				code, msg = status, domainErr.Message
+				if len(domainErr.Details) > 0 {
+					details = domainErr.Details
+				}
+			}
+		case errors.Is(err, context.DeadlineExceeded):
+			code = fiber.StatusGatewayTimeout
+			msg = fiber.ErrGatewayTimeout.Error()
+		}
 
-	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
-	return c.Status(code).JSON(&domain.Response{
-		Code:    code,
-		Message: msg,
-	})
+		if code >= fiber.StatusInternalServerError {
+			logger.Error().Err(err).
+				Str("request_id", c.GetRespHeader(fiber.HeaderXRequestID)).
+				Str("method", c.Method()).
+				Str("path", c.Path()).
+				Msg("Request failed")
+		}
+
+		return utilities.ErrorResponse(c, code, msg, details)
+	}
 }
@@ -1,39 +1,47 @@
 package infrastructure
 
 import (
+	"context"
 	"fmt"
+	"go-multiple-query/internal/audit"
 	"go-multiple-query/internal/docs"
+	auditMiddleware "go-multiple-query/internal/middleware/audit"
+	"go-multiple-query/internal/utilities"
 	"go-multiple-query/internal/voucher"
 	"go-multiple-query/pkg/xlogger"
+	"strings"
+	"time"
 
 	"github.com/gofiber/contrib/fiberzerolog"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/etag"
 	recover2 "github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/rs/zerolog"
 )
 
 func Run() {
+	setup()
 	logger := xlogger.Logger
 
 	app := fiber.New(fiber.Config{
 		ProxyHeader:           cfg.ProxyHeader,
 		DisableStartupMessage: true,
-		ErrorHandler:          defaultErrorHandler,
+		ErrorHandler:          newErrorHandler(logger),
 	})
-
-	app.Use(fiberzerolog.New(fiberzerolog.Config{
-		Logger: logger,
-		Fields: cfg.LogFields,
-	}))
-	app.Use(recover2.New())
-	app.Use(etag.New())
-	app.Use(requestid.New())
+	useMiddleware(app, logger)
 
 	// Grouping Routes
 	api := app.Group("/api")
+	api.Use(auditMiddleware.New(auditMiddleware.Config{
+		Service:  auditService,
+		Snapshot: voucherService.FindByID,
+		Logger:   logger,
+		Timeout:  cfg.QueryTimeout,
+	}))
 	docs.NewHttpHandler(api.Group("/docs"))
-	voucher.NewHTTPHandler(api.Group("/vouchers"), voucherService, logger)
+	voucher.NewHTTPHandler(api.Group("/vouchers"), voucherService, logger, cfg.ExportTimeout)
+	audit.NewHTTPHandler(api.Group("/audit"), auditService)
 
 	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
 	logger.Info().Msgf("Server is running on address: %s", addr)
@@ -41,3 +49,37 @@ func Run() {
 		logger.Fatal().Err(err).Msg("Server failed to start")
 	}
 }
+
+// useMiddleware installs the middleware every request goes through.
+func useMiddleware(app *fiber.App, logger *zerolog.Logger) {
+	app.Use(fiberzerolog.New(fiberzerolog.Config{
+		Logger: logger,
+		Fields: cfg.LogFields,
+	}))
+	app.Use(recover2.New())
+	app.Use(etag.New(etag.Config{
+		// The ETag is computed over the whole body, which would read the
+		// streamed export into memory
+		Next: func(c *fiber.Ctx) bool {
+			return strings.HasSuffix(c.Path(), "/vouchers/export")
+		},
+	}))
+	app.Use(requestid.New())
+	app.Use(requestTimeout(cfg.QueryTimeout))
+	if cfg.ProblemDetails {
+		app.Use(utilities.UseProblemDetails)
+	}
+}
+
+// requestTimeout gives the user context of every request a deadline, which
+// the services pass down to the database. fasthttp does not report client
+// disconnects, so the deadline is what stops abandoned queries.
+func requestTimeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
@@ -0,0 +1,58 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/voucher"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// exportVoucherService exports a fixed number of vouchers, the other
+// methods are not used.
+type exportVoucherService struct {
+	domain.VoucherService
+	vouchers int
+}
+
+func (s *exportVoucherService) Export(ctx context.Context, filter domain.VoucherFilter, each func(*domain.Voucher) error) error {
+	for i := 0; i < s.vouchers; i++ {
+		if err := each(&domain.Voucher{Sku: "GV-" + strconv.Itoa(i), SkuName: "Voucher " + strconv.Itoa(i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExport_MiddlewareStack(t *testing.T) {
+	cfg.QueryTimeout = time.Second
+	logger := zerolog.New(io.Discard)
+
+	app := fiber.New(fiber.Config{ErrorHandler: newErrorHandler(&logger)})
+	useMiddleware(app, &logger)
+	voucher.NewHTTPHandler(app.Group("/api/vouchers"), &exportVoucherService{vouchers: 20000}, &logger, time.Minute)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/vouchers/export?format=ndjson&fields=sku", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get(fiber.HeaderContentType))
+
+	// An ETag means the body was read into memory to compute it
+	assert.Empty(t, resp.Header.Get(fiber.HeaderETag))
+
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, 20000, lines)
+}
@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/utilities"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// voucherPathPattern finds the voucher ID in the path of a request.
+var voucherPathPattern = regexp.MustCompile(`/vouchers/([0-9a-fA-F]{24})(?:/|$)`)
+
+// Config configures the audit middleware.
+type Config struct {
+	// Service stores the audit entries.
+	Service domain.AuditService
+
+	// Snapshot loads the voucher a request changes, compared before and
+	// after the request to record what changed.
+	Snapshot func(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error)
+
+	// Logger reports audit entries that could not be stored.
+	Logger *zerolog.Logger
+
+	// Timeout bounds loading the voucher after the request and storing the
+	// entries, which go on once the request itself has run out of time.
+	Timeout time.Duration
+}
+
+// New records an audit entry for every POST, PUT, PATCH and DELETE request.
+// The voucher is taken from the path, or from the response of a request
+// creating one. Requests storing several vouchers, such as bulk creates and
+// imports, get an entry per stored voucher, built from the rows of the
+// report they respond with, changes included, and stored in one write.
+func New(config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		default:
+			return c.Next()
+		}
+
+		voucherId := voucherIDFromPath(c.Path())
+		var before *domain.Voucher
+		if voucherId != nil {
+			before = snapshot(c.UserContext(), config, *voucherId)
+		}
+
+		// Errors are turned into their response here rather than once the
+		// chain returns, so the entry records the status sent to the client
+		if err := c.Next(); err != nil {
+			if handlerErr := c.App().ErrorHandler(c, err); handlerErr != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		// The entry is stored even when the request ran out of time
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.UserContext()), config.Timeout)
+		defer cancel()
+
+		actor := utilities.ActorFromRequest(c)
+		newEntry := func() *domain.AuditEntry {
+			return &domain.AuditEntry{
+				Actor:     actor.Name,
+				RequestId: actor.RequestId,
+				Method:    c.Method(),
+				Route:     c.Route().Path,
+				Path:      c.Path(),
+				Status:    c.Response().StatusCode(),
+				CreatedAt: time.Now(),
+			}
+		}
+
+		var response voucherResponse
+		_ = json.Unmarshal(c.Response().Body(), &response)
+		if voucherId == nil {
+			voucherId = response.voucherID()
+		}
+
+		var entries []*domain.AuditEntry
+		switch {
+		case voucherId != nil:
+			entry := newEntry()
+			entry.VoucherId = voucherId
+			entry.Changes = diffVouchers(before, snapshot(ctx, config, *voucherId))
+			entries = append(entries, entry)
+		case !response.Data.DryRun:
+			for _, row := range response.Data.Results {
+				id, err := primitive.ObjectIDFromHex(row.Id)
+				if err != nil || !storedStatuses[row.Status] {
+					continue
+				}
+				entry := newEntry()
+				entry.VoucherId = &id
+				entry.Changes = row.Changes
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) == 0 {
+			entries = append(entries, newEntry())
+		}
+
+		if recordErr := config.Service.RecordMany(ctx, entries); recordErr != nil {
+			config.Logger.Error().Err(recordErr).Str("path", c.Path()).Int("entries", len(entries)).Msg("Failed to record audit entries")
+		}
+
+		return nil
+	}
+}
+
+// storedStatuses are the statuses of the report rows of vouchers a bulk or
+// import request stored.
+var storedStatuses = map[string]bool{
+	domain.BulkStatusCreated:   true,
+	domain.ImportStatusNew:     true,
+	domain.ImportStatusChanged: true,
+}
+
+// voucherResponse is the part of a domain.Response body naming the vouchers
+// a request changed: a single voucher, or the rows of a
+// domain.BulkVoucherReport or domain.VoucherImportReport. The rows carry
+// their own changes, which are recorded as they are.
+type voucherResponse struct {
+	Data struct {
+		Id      string `json:"id"`
+		DryRun  bool   `json:"dry_run"`
+		Results []struct {
+			Id      string                               `json:"id"`
+			Status  string                               `json:"status"`
+			Changes map[string]domain.VoucherFieldChange `json:"changes"`
+		} `json:"results"`
+	} `json:"data"`
+}
+
+// voucherID returns the ID of the single voucher in the response.
+func (r *voucherResponse) voucherID() *primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(r.Data.Id)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// snapshot loads a voucher, nil when it does not exist.
+func snapshot(ctx context.Context, config Config, id primitive.ObjectID) *domain.Voucher {
+	voucher, err := config.Snapshot(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return voucher
+}
+
+func voucherIDFromPath(path string) *primitive.ObjectID {
+	matches := voucherPathPattern.FindStringSubmatch(path)
+	if matches == nil {
+		return nil
+	}
+	id, err := primitive.ObjectIDFromHex(matches[1])
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// diffVouchers returns the json fields that differ between two versions of
+// a voucher. A nil voucher is one that does not exist.
+func diffVouchers(before, after *domain.Voucher) map[string]domain.VoucherFieldChange {
+	beforeFields, afterFields := jsonFields(before), jsonFields(after)
+
+	changes := map[string]domain.VoucherFieldChange{}
+	for field, value := range afterFields {
+		if old, ok := beforeFields[field]; !ok || !reflect.DeepEqual(old, value) {
+			changes[field] = domain.VoucherFieldChange{Old: old, New: value}
+		}
+	}
+	for field, old := range beforeFields {
+		if _, ok := afterFields[field]; !ok {
+			changes[field] = domain.VoucherFieldChange{Old: old}
+		}
+	}
+	return changes
+}
+
+func jsonFields(voucher *domain.Voucher) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if voucher == nil {
+		return fields
+	}
+	body, _ := json.Marshal(voucher)
+	_ = json.Unmarshal(body, &fields)
+	return fields
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"context"
+	"go-multiple-query/internal/domain"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeAuditService struct {
+	calls   int
+	entries []*domain.AuditEntry
+}
+
+func (f *fakeAuditService) RecordMany(ctx context.Context, entries []*domain.AuditEntry) error {
+	f.calls++
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+func (f *fakeAuditService) Find(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error) {
+	return &domain.AuditPage{}, nil
+}
+
+func newTestApp(service *fakeAuditService, vouchers map[primitive.ObjectID]*domain.Voucher) *fiber.App {
+	logger := zerolog.Nop()
+	app := fiber.New()
+	app.Use(New(Config{
+		Service: service,
+		Snapshot: func(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error) {
+			voucher, ok := vouchers[id]
+			if !ok {
+				return &domain.Voucher{}, fiber.ErrNotFound
+			}
+			copied := *voucher
+			return &copied, nil
+		},
+		Logger:  &logger,
+		Timeout: time.Second,
+	}))
+	return app
+}
+
+func TestAudit_Update(t *testing.T) {
+	id := primitive.NewObjectID()
+	vouchers := map[primitive.ObjectID]*domain.Voucher{id: {Id: id, Sku: "ALFM25", Stock: 5}}
+	service := &fakeAuditService{}
+
+	app := newTestApp(service, vouchers)
+	app.Put("/vouchers/:id", func(c *fiber.Ctx) error {
+		vouchers[id].Stock = 3
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	req := httptest.NewRequest("PUT", "/vouchers/"+id.Hex(), nil)
+	req.Header.Set("X-Actor", "finance")
+	_, err := app.Test(req)
+	assert.NoError(t, err)
+
+	assert.Len(t, service.entries, 1)
+	entry := service.entries[0]
+	assert.Equal(t, "finance", entry.Actor)
+	assert.Equal(t, "/vouchers/:id", entry.Route)
+	assert.Equal(t, fiber.StatusOK, entry.Status)
+	assert.Equal(t, id, *entry.VoucherId)
+	assert.Equal(t, map[string]domain.VoucherFieldChange{"stock": {Old: float64(5), New: float64(3)}}, entry.Changes)
+}
+
+func TestAudit_Create(t *testing.T) {
+	id := primitive.NewObjectID()
+	vouchers := map[primitive.ObjectID]*domain.Voucher{}
+	service := &fakeAuditService{}
+
+	app := newTestApp(service, vouchers)
+	app.Post("/vouchers", func(c *fiber.Ctx) error {
+		vouchers[id] = &domain.Voucher{Id: id, Sku: "ALFM25"}
+		return c.Status(fiber.StatusCreated).JSON(domain.Response{Data: vouchers[id]})
+	})
+
+	_, err := app.Test(httptest.NewRequest("POST", "/vouchers", nil))
+	assert.NoError(t, err)
+
+	assert.Len(t, service.entries, 1)
+	entry := service.entries[0]
+	assert.Equal(t, domain.AnonymousActor, entry.Actor)
+	assert.Equal(t, id, *entry.VoucherId)
+	assert.Equal(t, domain.VoucherFieldChange{New: "ALFM25"}, entry.Changes["sku"])
+}
+
+func TestAudit_SkipsReads(t *testing.T) {
+	service := &fakeAuditService{}
+
+	app := newTestApp(service, nil)
+	app.Get("/vouchers", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	_, err := app.Test(httptest.NewRequest("GET", "/vouchers", nil))
+	assert.NoError(t, err)
+	assert.Empty(t, service.entries)
+}
+
+func TestAudit_Bulk(t *testing.T) {
+	created, failed := primitive.NewObjectID(), primitive.NewObjectID()
+	service := &fakeAuditService{}
+
+	app := newTestApp(service, nil)
+	app.Post("/vouchers/bulk", func(c *fiber.Ctx) error {
+		return c.JSON(domain.Response{Data: &domain.BulkVoucherReport{Results: []*domain.BulkVoucherResult{
+			{Row: 1, Status: domain.BulkStatusCreated, Id: &created, Sku: "ALFM25", Changes: map[string]domain.VoucherFieldChange{"sku": {New: "ALFM25"}}},
+			{Row: 2, Status: domain.BulkStatusFailed, Id: &failed, Sku: "ALFM50"},
+			{Row: 3, Status: domain.BulkStatusInvalid},
+		}}})
+	})
+
+	_, err := app.Test(httptest.NewRequest("POST", "/vouchers/bulk", nil))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, service.calls)
+	assert.Len(t, service.entries, 1)
+	entry := service.entries[0]
+	assert.Equal(t, created, *entry.VoucherId)
+	assert.Equal(t, domain.VoucherFieldChange{New: "ALFM25"}, entry.Changes["sku"])
+}
+
+func TestAudit_Import(t *testing.T) {
+	added, changed := primitive.NewObjectID(), primitive.NewObjectID()
+	service := &fakeAuditService{}
+
+	app := newTestApp(service, nil)
+	app.Post("/vouchers/import", func(c *fiber.Ctx) error {
+		return c.JSON(domain.Response{Data: &domain.VoucherImportReport{
+			DryRun: c.QueryBool("dry_run"),
+			Results: []*domain.VoucherImportResult{
+				{Line: 2, Status: domain.ImportStatusNew, Id: &added, Changes: map[string]domain.VoucherFieldChange{"sku": {New: "ALFM25"}}},
+				{Line: 3, Status: domain.ImportStatusChanged, Id: &changed, Changes: map[string]domain.VoucherFieldChange{"stock": {Old: 5, New: 3}}},
+				{Line: 4, Status: domain.ImportStatusUnchanged, Id: &changed},
+			},
+		}})
+	})
+
+	_, err := app.Test(httptest.NewRequest("POST", "/vouchers/import", nil))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, service.calls)
+	assert.Len(t, service.entries, 2)
+	assert.Equal(t, added, *service.entries[0].VoucherId)
+	assert.Equal(t, domain.VoucherFieldChange{New: "ALFM25"}, service.entries[0].Changes["sku"])
+	assert.Equal(t, changed, *service.entries[1].VoucherId)
+	assert.Equal(t, map[string]domain.VoucherFieldChange{"stock": {Old: float64(5), New: float64(3)}}, service.entries[1].Changes)
+
+	service.entries = nil
+	_, err = app.Test(httptest.NewRequest("POST", "/vouchers/import?dry_run=true", nil))
+	assert.NoError(t, err)
+
+	assert.Len(t, service.entries, 1)
+	assert.Nil(t, service.entries[0].VoucherId)
+}
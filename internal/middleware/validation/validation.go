@@ -1,35 +1,331 @@
 package validation
 
 import (
+	"encoding/json"
+	"errors"
 	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/utilities"
+	"reflect"
+	"strconv"
+	"strings"
 
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/id"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	idTranslations "github.com/go-playground/validator/v10/translations/id"
 	"github.com/gofiber/fiber/v2"
 )
 
+// MaxPageSize is the largest page size the page_size rule accepts.
+const MaxPageSize = 100
+
+var (
+	validate   = newValidator()
+	translator = newTranslator(validate)
+)
+
+// errorCodes are the stable codes reported for the failed validation rules.
+// Rules without a code of their own are reported as invalid.
+var errorCodes = map[string]string{
+	"required":       "required",
+	"min":            "below_minimum",
+	"gt":             "below_minimum",
+	"gte":            "below_minimum",
+	"max":            "above_maximum",
+	"lt":             "above_maximum",
+	"lte":            "above_maximum",
+	"email":          "invalid_email",
+	"oneof":          "invalid_choice",
+	"product_status": "invalid_choice",
+	"page":           "below_minimum",
+	"page_size":      "out_of_range",
+	"unknown_field":  "unknown_field",
+	"repeated_field": "duplicate",
+	"filterable":     "unsupported",
+	"operator":       "unsupported",
+	"numeric_only":   "unsupported",
+	"text_only":      "unsupported",
+}
+
+// ruleMessages are the English and Indonesian messages of the rules that are
+// checked without validate tags, see FieldError. {1} is the parameter of the
+// rule.
+var ruleMessages = map[string][2]string{
+	"invalid":        {"{0} is invalid", "{0} tidak valid"},
+	"boolean":        {"{0} must be a valid boolean value", "{0} harus berupa nilai boolean yang valid"},
+	"unknown_field":  {"{0} has unknown field {1}", "{0} berisi field {1} yang tidak dikenal"},
+	"repeated_field": {"{0} repeats field {1}", "{0} mengulang field {1}"},
+	"filterable":     {"{0} is not a filterable field", "{0} bukan field yang dapat difilter"},
+	"operator":       {"{0} is not a supported operator", "{0} bukan operator yang didukung"},
+	"numeric_only":   {"{0} is only supported on numeric fields", "{0} hanya didukung pada field numerik"},
+	"text_only":      {"{0} is only supported on text fields", "{0} hanya didukung pada field teks"},
+	"cursor":         {"{0} is invalid or does not match order_by", "{0} tidak valid atau tidak sesuai dengan order_by"},
+	"object_id":      {"{0} must be a voucher ID", "{0} harus berupa ID voucher"},
+	"rfc3339":        {"{0} must be an RFC 3339 timestamp", "{0} harus berupa timestamp RFC 3339"},
+}
+
 func New[V any]() fiber.Handler {
-	validate := validator.New(validator.WithRequiredStructEnabled())
 	return func(c *fiber.Ctx) error {
 		var v V
 		if err := c.BodyParser(&v); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			return decodeErrorResponse(c, err, "request body is malformed")
 		}
 		if err := validate.Struct(v); err != nil {
-			var errors []string
-			for _, err := range err.(validator.ValidationErrors) {
-				message := err.Field() + " is " + err.Tag()
-				if err.Param() != "" {
-					message += " " + err.Param()
-				}
-				errors = append(errors, message)
+			return validationErrorResponse(c, err)
+		}
+		c.Locals("parser", &v)
+		return c.Next()
+	}
+}
+
+// NewMergePatch validates a JSON Merge Patch (RFC 7396) document against the
+// rules of V. Only the fields present in the patch are validated, a null
+// value is validated as the field being removed. The patch is stored as a
+// map[string]json.RawMessage for ExtractStructFromValidator.
+func NewMergePatch[V any]() fiber.Handler {
+	fields := jsonFieldNames[V]()
+	return func(c *fiber.Ctx) error {
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(c.Body(), &patch); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "request body must be a JSON object")
+		}
+
+		var partial []string
+		for key := range patch {
+			field, ok := fields[key]
+			if !ok {
+				return fiber.NewError(fiber.StatusBadRequest, "unknown field "+key)
 			}
-			return c.Status(fiber.StatusBadRequest).JSON(domain.Response{
-				Code:    fiber.StatusBadRequest,
-				Errors:  errors,
-				Message: "validation error",
-			})
+			partial = append(partial, field)
+		}
+
+		var v V
+		if err := json.Unmarshal(c.Body(), &v); err != nil {
+			return decodeErrorResponse(c, err, "request body must be a JSON object")
+		}
+		if err := validate.StructPartial(v, partial...); err != nil {
+			return validationErrorResponse(c, err)
+		}
+		c.Locals("parser", &patch)
+		return c.Next()
+	}
+}
+
+// NewQuery validates the query string of a request against the rules of V,
+// read with the query tags of its fields. Numbers are best read into string
+// fields checked with the number rule, so a word given for a number is
+// reported like any other failed rule. The parsed V is stored for
+// ExtractStructFromValidator.
+func NewQuery[V any]() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var v V
+		if err := c.QueryParser(&v); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "query string is malformed")
+		}
+		if err := validate.Struct(v); err != nil {
+			return validationErrorResponse(c, err)
 		}
 		c.Locals("parser", &v)
 		return c.Next()
 	}
 }
+
+// newValidator creates a validator with the domain specific rules
+// registered. Failed fields are named by their json name, or their query
+// name for query string parameters.
+func newValidator() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "query"} {
+			if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+		return field.Name
+	})
+	_ = validate.RegisterValidation("product_status", func(fl validator.FieldLevel) bool {
+		return domain.ProductStatus(fl.Field().String()).IsValid()
+	})
+	_ = validate.RegisterValidation("page", func(fl validator.FieldLevel) bool {
+		page, err := strconv.Atoi(fl.Field().String())
+		return err == nil && page >= 1
+	})
+	_ = validate.RegisterValidation("page_size", func(fl validator.FieldLevel) bool {
+		size, err := strconv.Atoi(fl.Field().String())
+		return err == nil && size >= 1 && size <= MaxPageSize
+	})
+	return validate
+}
+
+// newTranslator registers the English and Indonesian messages of every rule
+// of validate. English is used for any other language.
+func newTranslator(validate *validator.Validate) *ut.UniversalTranslator {
+	english := en.New()
+	translator := ut.New(english, english, id.New())
+
+	enTrans, _ := translator.GetTranslator("en")
+	_ = enTranslations.RegisterDefaultTranslations(validate, enTrans)
+	registerTranslation(validate, enTrans, "product_status", "{0} must be one of draft, available, out_of_stock or discontinued")
+	registerTranslation(validate, enTrans, "page", "{0} must be at least 1")
+	registerTranslation(validate, enTrans, "page_size", "{0} must be between 1 and "+strconv.Itoa(MaxPageSize))
+
+	idTrans, _ := translator.GetTranslator("id")
+	_ = idTranslations.RegisterDefaultTranslations(validate, idTrans)
+	registerTranslation(validate, idTrans, "product_status", "{0} harus salah satu dari draft, available, out_of_stock atau discontinued")
+	registerTranslation(validate, idTrans, "page", "{0} minimal 1")
+	registerTranslation(validate, idTrans, "page_size", "{0} harus antara 1 dan "+strconv.Itoa(MaxPageSize))
+
+	// Rules with a default translation keep it
+	for rule, messages := range ruleMessages {
+		_ = enTrans.Add(rule, messages[0], false)
+		_ = idTrans.Add(rule, messages[1], false)
+	}
+
+	return translator
+}
+
+// registerTranslation adds the message of a rule without a default
+// translation.
+func registerTranslation(validate *validator.Validate, trans ut.Translator, tag, message string) {
+	_ = validate.RegisterTranslation(tag, trans, func(trans ut.Translator) error {
+		return trans.Add(tag, message, false)
+	}, func(trans ut.Translator, fe validator.FieldError) string {
+		message, _ := trans.T(tag, fe.Field())
+		return message
+	})
+}
+
+// Translator returns the translator of the language the client of a request
+// prefers, for the messages of Validate, ValidatePartial and FieldError.
+func Translator(c *fiber.Ctx) ut.Translator {
+	return TranslatorFor(c.AcceptsLanguages("en", "id"))
+}
+
+// TranslatorFor returns the translator of language, English for a language
+// without messages.
+func TranslatorFor(language string) ut.Translator {
+	trans, _ := translator.GetTranslator(language)
+	return trans
+}
+
+// Validate checks v against its validate tags outside of a request, such as
+// the items of a bulk request, and returns the failed rules with messages
+// from trans.
+func Validate(v interface{}, trans ut.Translator) []domain.FieldError {
+	if err := validate.Struct(v); err != nil {
+		return fieldErrors(err, trans)
+	}
+	return nil
+}
+
+// ValidatePartial is like Validate but only checks the fields of v with the
+// given json names.
+func ValidatePartial(v interface{}, trans ut.Translator, fields ...string) []domain.FieldError {
+	names := jsonFieldNamesOf(reflect.Indirect(reflect.ValueOf(v)).Type())
+
+	var partial []string
+	for _, field := range fields {
+		if name, ok := names[field]; ok {
+			partial = append(partial, name)
+		}
+	}
+	if len(partial) == 0 {
+		return nil
+	}
+
+	if err := validate.StructPartial(v, partial...); err != nil {
+		return fieldErrors(err, trans)
+	}
+	return nil
+}
+
+// FieldError reports a field failing a rule that is checked without
+// validate tags, such as a value that cannot be parsed, with the message of
+// the rule from trans. Rules with a parameter, such as oneof, take it as
+// param.
+func FieldError(trans ut.Translator, field, rule string, param ...string) domain.FieldError {
+	message, err := trans.T(rule, append([]string{field}, param...)...)
+	if err != nil {
+		message, _ = trans.T("invalid", field)
+	}
+	return domain.FieldError{Field: field, Rule: rule, Param: strings.Join(param, " "), Code: errorCode(rule), Message: message}
+}
+
+// DecodeError reports a JSON value that could not be decoded into its
+// field, such as a string given for a number, as a field error with the
+// message of trans. It reports false for other errors, such as malformed
+// JSON, which are not described by field.
+func DecodeError(trans ut.Translator, err error) (domain.FieldError, bool) {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return domain.FieldError{}, false
+	}
+
+	rule := "invalid"
+	switch typeErr.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		rule = "number"
+	case reflect.Bool:
+		rule = "boolean"
+	}
+	return FieldError(trans, typeErr.Field, rule), true
+}
+
+// decodeErrorResponse reports a request body that could not be decoded, as
+// a field error when DecodeError describes it and with message otherwise.
+// The error of the decoder is not returned, as it names Go types.
+func decodeErrorResponse(c *fiber.Ctx, err error, message string) error {
+	if fieldErr, ok := DecodeError(Translator(c), err); ok {
+		return utilities.ErrorResponse(c, fiber.StatusBadRequest, "validation error", []domain.FieldError{fieldErr})
+	}
+	return fiber.NewError(fiber.StatusBadRequest, message)
+}
+
+// validationErrorResponse reports every failed rule as a domain.FieldError,
+// with a message in the language the client prefers.
+func validationErrorResponse(c *fiber.Ctx, err error) error {
+	return utilities.ErrorResponse(c, fiber.StatusBadRequest, "validation error", fieldErrors(err, Translator(c)))
+}
+
+func fieldErrors(err error, trans ut.Translator) []domain.FieldError {
+	var errors []domain.FieldError
+	for _, err := range err.(validator.ValidationErrors) {
+		errors = append(errors, domain.FieldError{
+			Field:   err.Field(),
+			Rule:    err.Tag(),
+			Param:   err.Param(),
+			Code:    errorCode(err.Tag()),
+			Message: err.Translate(trans),
+		})
+	}
+	return errors
+}
+
+// errorCode returns the code reported for a failed rule, see errorCodes.
+func errorCode(rule string) string {
+	if code, ok := errorCodes[rule]; ok {
+		return code
+	}
+	return "invalid"
+}
+
+// jsonFieldNames maps the json names of V's fields to their Go names.
+func jsonFieldNames[V any]() map[string]string {
+	return jsonFieldNamesOf(reflect.TypeOf((*V)(nil)).Elem())
+}
+
+func jsonFieldNamesOf(t reflect.Type) map[string]string {
+	fields := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		fields[name] = t.Field(i).Name
+	}
+	return fields
+}
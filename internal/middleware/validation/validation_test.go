@@ -2,8 +2,10 @@ package validation
 
 import (
 	"bytes"
+	"encoding/json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"go-multiple-query/internal/domain"
 	"net/http/httptest"
 	"testing"
 )
@@ -59,3 +61,205 @@ func TestValidation_InvalidBodyPost(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 400, resp.StatusCode)
 }
+
+func TestValidation_FieldErrors(t *testing.T) {
+	type Payload struct {
+		Name   string               `json:"name" validate:"required,min=5"`
+		Status domain.ProductStatus `json:"status" validate:"required,product_status"`
+	}
+
+	app := fiber.New()
+	app.Post("/", New[Payload](), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		language string
+		expected []domain.FieldError
+	}{
+		{"", []domain.FieldError{
+			{Field: "name", Rule: "min", Param: "5", Code: "below_minimum", Message: "name must be at least 5 characters in length"},
+			{Field: "status", Rule: "product_status", Code: "invalid_choice", Message: "status must be one of draft, available, out_of_stock or discontinued"},
+		}},
+		{"id-ID,id;q=0.9", []domain.FieldError{
+			{Field: "name", Rule: "min", Param: "5", Code: "below_minimum", Message: "panjang minimal name adalah 5 karakter"},
+			{Field: "status", Rule: "product_status", Code: "invalid_choice", Message: "status harus salah satu dari draft, available, out_of_stock atau discontinued"},
+		}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"name":"John","status":"active"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", test.language)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode)
+
+		var response struct {
+			Errors []domain.FieldError `json:"errors"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		assert.Equal(t, test.expected, response.Errors, test.language)
+	}
+}
+
+func TestValidation_Query(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", NewQuery[domain.PageQuery](), func(c *fiber.Ctx) error {
+		return c.JSON(c.Locals("parser"))
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/?page=2&size=20", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var query domain.PageQuery
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&query))
+	assert.Equal(t, domain.PageQuery{Page: "2", Size: "20"}, query)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	for _, target := range []string{"/?size=abc", "/?page=-3", "/?page=0", "/?size=101"} {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, target)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/?size=500", nil))
+	assert.NoError(t, err)
+
+	var response struct {
+		Errors []domain.FieldError `json:"errors"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.Equal(t, []domain.FieldError{
+		{Field: "size", Rule: "page_size", Code: "out_of_range", Message: "size must be between 1 and 100"},
+	}, response.Errors)
+
+	req := httptest.NewRequest("GET", "/?size=abc&page=x", nil)
+	req.Header.Set("Accept-Language", "id")
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.Equal(t, []domain.FieldError{
+		{Field: "page", Rule: "number", Code: "invalid", Message: "page harus berupa angka yang valid"},
+		{Field: "size", Rule: "number", Code: "invalid", Message: "size harus berupa angka yang valid"},
+	}, response.Errors)
+}
+
+func TestValidation_SuccessMergePatch(t *testing.T) {
+	type Payload struct {
+		Name  string `json:"name" validate:"required"`
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	app := fiber.New()
+	app.Patch("/", NewMergePatch[Payload](), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	body := `{"name":"John Doe"}`
+	req := httptest.NewRequest("PATCH", "/", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestValidation_InvalidMergePatch(t *testing.T) {
+	type Payload struct {
+		Name  string `json:"name" validate:"required"`
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	app := fiber.New()
+	app.Patch("/", NewMergePatch[Payload](), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for _, body := range []string{`{"name":null}`, `{"email":"john"}`, `{"age":30}`, `[]`} {
+		req := httptest.NewRequest("PATCH", "/", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, resp.StatusCode, body)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type Payload struct {
+		Name string `json:"name" validate:"required,min=5"`
+	}
+
+	trans, _ := translator.GetTranslator("en")
+	assert.Empty(t, Validate(Payload{Name: "John Doe"}, trans))
+	assert.Equal(t, []domain.FieldError{{
+		Field:   "name",
+		Rule:    "min",
+		Param:   "5",
+		Code:    "below_minimum",
+		Message: "name must be at least 5 characters in length",
+	}}, Validate(Payload{Name: "John"}, trans))
+}
+
+func TestValidatePartial(t *testing.T) {
+	type Payload struct {
+		Name  string `json:"name" validate:"required,min=5"`
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	trans, _ := translator.GetTranslator("id")
+	assert.Empty(t, ValidatePartial(&Payload{Name: "John Doe"}, trans, "name"))
+	errs := ValidatePartial(&Payload{Email: "john"}, trans, "email")
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "email", errs[0].Field)
+	assert.Equal(t, "invalid_email", errs[0].Code)
+	assert.Equal(t, "email harus berupa alamat email yang valid", errs[0].Message)
+}
+
+func TestValidate_ProductStatus(t *testing.T) {
+	type Payload struct {
+		Status string `json:"status" validate:"required,product_status"`
+	}
+
+	trans, _ := translator.GetTranslator("en")
+	assert.Empty(t, Validate(Payload{Status: "available"}, trans))
+	errs := Validate(Payload{Status: "active"}, trans)
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "invalid_choice", errs[0].Code)
+	assert.Equal(t, "status must be one of draft, available, out_of_stock or discontinued", errs[0].Message)
+}
+
+func TestFieldError(t *testing.T) {
+	trans, _ := translator.GetTranslator("en")
+	assert.Equal(t, domain.FieldError{
+		Field:   "stock",
+		Rule:    "number",
+		Code:    "invalid",
+		Message: "stock must be a valid number",
+	}, FieldError(trans, "stock", "number"))
+	assert.Equal(t, "sku is a required field", FieldError(trans, "sku", "required").Message)
+	assert.Equal(t, domain.FieldError{
+		Field:   "sort_order",
+		Rule:    "oneof",
+		Param:   "asc desc",
+		Code:    "invalid_choice",
+		Message: "sort_order must be one of [asc desc]",
+	}, FieldError(trans, "sort_order", "oneof", "asc desc"))
+}
+
+func TestDecodeError(t *testing.T) {
+	trans, _ := translator.GetTranslator("en")
+	var payload struct {
+		Stock int `json:"stock"`
+	}
+
+	fieldErr, ok := DecodeError(trans, json.Unmarshal([]byte(`{"stock":"many"}`), &payload))
+	assert.True(t, ok)
+	assert.Equal(t, domain.FieldError{Field: "stock", Rule: "number", Code: "invalid", Message: "stock must be a valid number"}, fieldErr)
+
+	_, ok = DecodeError(trans, json.Unmarshal([]byte(`{"stock":`), &payload))
+	assert.False(t, ok)
+}
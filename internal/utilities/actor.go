@@ -0,0 +1,19 @@
+package utilities
+
+import (
+	"go-multiple-query/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ActorHeader is the request header naming the client that makes a change.
+const ActorHeader = "X-Actor"
+
+// ActorFromRequest identifies who makes a change through the request, along
+// with the request ID set by the requestid middleware.
+func ActorFromRequest(c *fiber.Ctx) domain.Actor {
+	return domain.Actor{
+		Name:      c.Get(ActorHeader, domain.AnonymousActor),
+		RequestId: c.GetRespHeader(fiber.HeaderXRequestID),
+	}
+}
@@ -0,0 +1,34 @@
+package utilities
+
+import (
+	"encoding/json"
+	"go-multiple-query/internal/domain"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActorFromRequest(t *testing.T) {
+	app := fiber.New()
+	app.Use(requestid.New(requestid.Config{Generator: func() string { return "req-1" }}))
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.JSON(ActorFromRequest(c))
+	})
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(ActorHeader, "finance")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+
+	var actor domain.Actor
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&actor))
+	assert.Equal(t, domain.Actor{Name: "finance", RequestId: "req-1"}, actor)
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/", nil))
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&actor))
+	assert.Equal(t, domain.AnonymousActor, actor.Name)
+}
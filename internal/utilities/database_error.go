@@ -0,0 +1,28 @@
+package utilities
+
+import (
+	"context"
+	"errors"
+	"go-multiple-query/internal/domain"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// DatabaseError converts the database errors a client can be told about into
+// domain errors: an unreachable database and queries that ran out of time.
+// Domain errors and any other error are returned unchanged.
+func DatabaseError(err error) error {
+	var domainErr *domain.Error
+	switch {
+	case err == nil, errors.As(err, &domainErr):
+		return err
+	case errors.As(err, &topology.ServerSelectionError{}),
+		errors.Is(err, mongo.ErrClientDisconnected),
+		mongo.IsNetworkError(err):
+		return domain.Unavailable(err)
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return domain.Timeout(err)
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package utilities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multiple-query/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestDatabaseError(t *testing.T) {
+	err := DatabaseError(fmt.Errorf("find vouchers: %w", context.DeadlineExceeded))
+	assert.ErrorIs(t, err, domain.ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = DatabaseError(topology.ServerSelectionError{Wrapped: topology.ErrServerSelectionTimeout})
+	assert.ErrorIs(t, err, domain.ErrUnavailable)
+
+	assert.ErrorIs(t, DatabaseError(mongo.ErrClientDisconnected), domain.ErrUnavailable)
+
+	notFound := domain.NotFound("Voucher not found")
+	assert.Equal(t, notFound, DatabaseError(notFound))
+
+	internalErr := errors.New("unexpected document")
+	assert.Equal(t, internalErr, DatabaseError(internalErr))
+	assert.Nil(t, DatabaseError(nil))
+}
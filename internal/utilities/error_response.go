@@ -0,0 +1,43 @@
+package utilities
+
+import (
+	"go-multiple-query/internal/domain"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// problemDetailsLocal marks the requests whose errors are always written as
+// problem details.
+const problemDetailsLocal = "problem_details"
+
+// UseProblemDetails writes the errors of every request it handles as RFC
+// 7807 problem details, whatever the client accepts.
+func UseProblemDetails(c *fiber.Ctx) error {
+	c.Locals(problemDetailsLocal, true)
+	return c.Next()
+}
+
+// ErrorResponse writes an error response. It is an RFC 7807 problem details
+// document when UseProblemDetails is in use or the client prefers
+// application/problem+json, and a domain.Response otherwise.
+func ErrorResponse(c *fiber.Ctx, code int, message string, errors interface{}) error {
+	forced, _ := c.Locals(problemDetailsLocal).(bool)
+	if forced || c.Accepts(fiber.MIMEApplicationJSON, domain.MIMEApplicationProblemJSON) == domain.MIMEApplicationProblemJSON {
+		return c.Status(code).JSON(domain.Problem{
+			Type:     "about:blank",
+			Title:    utils.StatusMessage(code),
+			Status:   code,
+			Detail:   message,
+			Instance: c.GetRespHeader(fiber.HeaderXRequestID),
+			Errors:   errors,
+		}, domain.MIMEApplicationProblemJSON)
+	}
+
+	return c.Status(code).JSON(domain.Response{
+		Code:    code,
+		Status:  "error",
+		Message: message,
+		Errors:  errors,
+	})
+}
@@ -0,0 +1,59 @@
+package utilities
+
+import (
+	"encoding/json"
+	"go-multiple-query/internal/domain"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorResponse(t *testing.T) {
+	handler := func(c *fiber.Ctx) error {
+		return ErrorResponse(c, fiber.StatusBadRequest, "validation error", []string{"Sku is required"})
+	}
+
+	app := fiber.New()
+	app.Use(requestid.New(requestid.Config{Generator: func() string { return "req-1" }}))
+	app.Get("/", handler)
+	app.Get("/problem", UseProblemDetails, handler)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, fiber.MIMEApplicationJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	var response domain.Response
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.Equal(t, domain.Response{Code: fiber.StatusBadRequest, Status: "error", Message: "validation error", Errors: []interface{}{"Sku is required"}}, response)
+
+	expected := domain.Problem{
+		Type:     "about:blank",
+		Title:    "Bad Request",
+		Status:   fiber.StatusBadRequest,
+		Detail:   "validation error",
+		Instance: "req-1",
+		Errors:   []interface{}{"Sku is required"},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderAccept, "application/problem+json, application/json;q=0.9")
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.MIMEApplicationProblemJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	var problem domain.Problem
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, expected, problem)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/problem", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, domain.MIMEApplicationProblemJSON, resp.Header.Get(fiber.HeaderContentType))
+
+	problem = domain.Problem{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, expected, problem)
+}
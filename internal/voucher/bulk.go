@@ -0,0 +1,50 @@
+package voucher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"go-multiple-query/internal/domain"
+	"strings"
+)
+
+// maxBulkVouchers limits the number of rows of a single bulk request.
+const maxBulkVouchers = 1000
+
+// bulkRow is a single row of a bulk request. Rows that fail to decode keep
+// their error so they can be reported without failing the whole batch.
+type bulkRow struct {
+	request *domain.StoreVoucherRequest
+	err     error
+}
+
+// decodeBulkRows reads the rows of a bulk request body, either a JSON array
+// or newline delimited JSON when the content type is application/x-ndjson.
+func decodeBulkRows(contentType string, body []byte) ([]bulkRow, error) {
+	var raws []json.RawMessage
+	if strings.HasPrefix(contentType, "application/x-ndjson") {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			raws = append(raws, append(json.RawMessage(nil), line...))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(body, &raws); err != nil {
+		return nil, err
+	}
+
+	rows := make([]bulkRow, 0, len(raws))
+	for _, raw := range raws {
+		var request domain.StoreVoucherRequest
+		err := json.Unmarshal(raw, &request)
+		rows = append(rows, bulkRow{request: &request, err: err})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,30 @@
+package voucher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeBulkRows_JSONArray(t *testing.T) {
+	rows, err := decodeBulkRows("application/json", []byte(`[{"sku":"ALFM25"},{"sku":1}]`))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.NoError(t, rows[0].err)
+	assert.Equal(t, "ALFM25", rows[0].request.Sku)
+	assert.Error(t, rows[1].err)
+}
+
+func TestDecodeBulkRows_NDJSON(t *testing.T) {
+	body := "{\"sku\":\"ALFM25\"}\n\n{\"sku\":\"IDMR50\"}\nnot json\n"
+	rows, err := decodeBulkRows("application/x-ndjson", []byte(body))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "IDMR50", rows[1].request.Sku)
+	assert.Error(t, rows[2].err)
+}
+
+func TestDecodeBulkRows_Invalid(t *testing.T) {
+	_, err := decodeBulkRows("application/json", []byte(`{"sku":"ALFM25"}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package voucher
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-multiple-query/internal/domain"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorPayload is the signed content of a pagination cursor: the sort it was
+// issued for and the sort key values of the last voucher on the page.
+type cursorPayload struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+// encodeCursor builds an opaque cursor pointing after the given voucher. The
+// cursor is signed with the secret so clients cannot forge sort key values.
+func encodeCursor(secret []byte, sortKeys bson.D, last *domain.Voucher) (string, error) {
+	raw, err := bson.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+
+	values := make([]interface{}, 0, len(sortKeys))
+	for _, key := range sortKeys {
+		values = append(values, bson.Raw(raw).Lookup(key.Key))
+	}
+
+	payload, err := bson.Marshal(cursorPayload{Sort: sortSpec(sortKeys), Values: values})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCursor(secret, payload)), nil
+}
+
+// decodeCursor verifies the cursor signature and returns the sort key values
+// it holds. Cursors issued for a different sort are rejected.
+func decodeCursor(secret []byte, cursor string, sortKeys bson.D) ([]interface{}, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	if !hmac.Equal(signature, signCursor(secret, payload)) {
+		return nil, errInvalidCursor
+	}
+
+	var decoded cursorPayload
+	if err := bson.Unmarshal(payload, &decoded); err != nil {
+		return nil, errInvalidCursor
+	}
+	if decoded.Sort != sortSpec(sortKeys) || len(decoded.Values) != len(sortKeys) {
+		return nil, errInvalidCursor
+	}
+
+	return decoded.Values, nil
+}
+
+// keysetCondition matches the documents that sort after the given values.
+func keysetCondition(sortKeys bson.D, values []interface{}) bson.M {
+	clauses := bson.A{}
+	for i, key := range sortKeys {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[sortKeys[j].Key] = values[j]
+		}
+
+		operator := "$gt"
+		if key.Value == -1 {
+			operator = "$lt"
+		}
+		clause[key.Key] = bson.M{operator: values[i]}
+
+		clauses = append(clauses, clause)
+	}
+
+	return bson.M{"$or": clauses}
+}
+
+func signCursor(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func sortSpec(sortKeys bson.D) string {
+	var parts []string
+	for _, key := range sortKeys {
+		parts = append(parts, fmt.Sprintf("%s:%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,48 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	secret := []byte("secret")
+	sortKeys := bson.D{{Key: "nominal", Value: 1}, {Key: "_id", Value: 1}}
+	last := &domain.Voucher{Id: primitive.NewObjectID(), Nominal: 15000}
+
+	cursor, err := encodeCursor(secret, sortKeys, last)
+	assert.NoError(t, err)
+
+	values, err := decodeCursor(secret, cursor, sortKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(15000), last.Id}, values)
+}
+
+func TestCursor_Rejected(t *testing.T) {
+	sortKeys := bson.D{{Key: "nominal", Value: 1}, {Key: "_id", Value: 1}}
+	cursor, err := encodeCursor([]byte("secret"), sortKeys, &domain.Voucher{Id: primitive.NewObjectID()})
+	assert.NoError(t, err)
+
+	_, err = decodeCursor([]byte("other"), cursor, sortKeys)
+	assert.ErrorIs(t, err, errInvalidCursor)
+
+	_, err = decodeCursor([]byte("secret"), cursor, bson.D{{Key: "stock", Value: 1}, {Key: "_id", Value: 1}})
+	assert.ErrorIs(t, err, errInvalidCursor)
+
+	_, err = decodeCursor([]byte("secret"), "not-a-cursor", sortKeys)
+	assert.ErrorIs(t, err, errInvalidCursor)
+}
+
+func TestKeysetCondition(t *testing.T) {
+	sortKeys := bson.D{{Key: "nominal", Value: -1}, {Key: "_id", Value: 1}}
+	id := primitive.NewObjectID()
+
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"nominal": bson.M{"$lt": 15000}},
+		bson.M{"nominal": 15000, "_id": bson.M{"$gt": id}},
+	}}, keysetCondition(sortKeys, []interface{}{15000, id}))
+}
@@ -0,0 +1,85 @@
+package voucher
+
+import (
+	"errors"
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/middleware/validation"
+	"go-multiple-query/internal/utilities"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errVoucherNotFound     = domain.NotFound("Voucher not found")
+	errReservationNotFound = domain.NotFound("Reservation not found")
+)
+
+// domainError converts the errors of the voucher repository and filter
+// parsing into domain errors carrying the message returned to clients.
+// Unexpected errors are returned unchanged.
+func domainError(err error) error {
+	var duplicateErr *domain.DuplicateSkuError
+	var stockErr *domain.InsufficientStockError
+	var transitionErr *domain.InvalidStatusTransitionError
+	var filterErr *domain.InvalidFilterError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return errVoucherNotFound
+	case errors.Is(err, domain.ErrReservationNotFound):
+		return errReservationNotFound
+	case errors.Is(err, domain.ErrReservationClosed):
+		return domain.Conflict("Reservation has already been released or has expired", err)
+	case errors.Is(err, domain.ErrVoucherNotArchived):
+		return domain.Conflict("Voucher is not archived", err)
+	case errors.Is(err, domain.ErrVoucherChanged):
+		return domain.Conflict("Voucher was changed by another request, fetch it and retry", err)
+	case errors.Is(err, domain.ErrReleaseExceedsReservation):
+		return domain.Conflict("Release quantity exceeds the reserved quantity", err)
+	case errors.As(err, &duplicateErr) && duplicateErr.Archived:
+		return domain.Conflict("Voucher with SKU "+duplicateErr.Sku+" already exists as an archived voucher, restore it instead", err)
+	case errors.As(err, &duplicateErr):
+		return domain.Conflict("Voucher with SKU "+duplicateErr.Sku+" already exists", err)
+	case errors.As(err, &stockErr):
+		return domain.Conflict("Insufficient stock, "+strconv.Itoa(stockErr.Available)+" available", err)
+	case errors.As(err, &transitionErr):
+		return domain.Conflict("Voucher status cannot change from "+string(transitionErr.From)+" to "+string(transitionErr.To), err)
+	case errors.As(err, &filterErr):
+		// The rejected parameters are described by filterError, in the
+		// language of the client
+		return &domain.Error{Kind: domain.ErrInvalid, Message: "Invalid filter parameters", Err: err}
+	}
+	return utilities.DatabaseError(err)
+}
+
+// filterError describes the rejected parameters of an invalid filter as
+// field errors in the language of the client. Other errors are returned
+// unchanged.
+func filterError(c *fiber.Ctx, err error) error {
+	var filterErr *domain.InvalidFilterError
+	if !errors.As(err, &filterErr) {
+		return err
+	}
+
+	trans := validation.Translator(c)
+	details := make([]domain.FieldError, 0, len(filterErr.Params))
+	for _, param := range filterErr.Params {
+		var params []string
+		if param.Param != "" {
+			params = append(params, param.Param)
+		}
+		details = append(details, validation.FieldError(trans, param.Name, param.Rule, params...))
+	}
+	return domain.Invalid("Invalid filter parameters", details...)
+}
+
+// rowErrorMessage returns the message reported for a bulk or imported row
+// that could not be written. Unexpected errors are not described to clients.
+func rowErrorMessage(err error) string {
+	var domainErr *domain.Error
+	if errors.As(domainError(err), &domainErr) {
+		return domainErr.Message
+	}
+	return "Voucher could not be stored"
+}
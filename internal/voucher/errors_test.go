@@ -0,0 +1,68 @@
+package voucher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-multiple-query/internal/domain"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDomainError(t *testing.T) {
+	err := domainError(mongo.ErrNoDocuments)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	assert.Equal(t, "Voucher not found", err.Error())
+
+	duplicateErr := &domain.DuplicateSkuError{Sku: "GV-100"}
+	err = domainError(duplicateErr)
+	assert.ErrorIs(t, err, domain.ErrConflict)
+	assert.ErrorAs(t, err, &duplicateErr)
+	assert.Equal(t, "Voucher with SKU GV-100 already exists", err.(*domain.Error).Message)
+
+	err = domainError(&domain.DuplicateSkuError{Sku: "GV-100", Archived: true})
+	assert.Equal(t, "Voucher with SKU GV-100 already exists as an archived voucher, restore it instead", err.(*domain.Error).Message)
+
+	err = domainError(&domain.InvalidFilterError{Params: []domain.InvalidFilterParam{{Name: "stock", Rule: "number"}}})
+	assert.ErrorIs(t, err, domain.ErrInvalid)
+
+	err = domainError(fmt.Errorf("find vouchers: %w", context.DeadlineExceeded))
+	assert.ErrorIs(t, err, domain.ErrTimeout)
+
+	internalErr := errors.New("connection refused")
+	assert.Equal(t, internalErr, domainError(internalErr))
+}
+
+func TestFilterError(t *testing.T) {
+	filterErr := domainError(&domain.InvalidFilterError{Params: []domain.InvalidFilterParam{
+		{Name: "order_by", Rule: "unknown_field", Param: "password"},
+		{Name: "stock", Rule: "number"},
+	}})
+
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		err = filterError(c, filterErr)
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(fiber.HeaderAcceptLanguage, "id")
+	_, testErr := app.Test(req)
+	assert.NoError(t, testErr)
+
+	assert.ErrorIs(t, err, domain.ErrInvalid)
+	assert.Equal(t, []domain.FieldError{
+		{Field: "order_by", Rule: "unknown_field", Param: "password", Code: "unknown_field", Message: "order_by berisi field password yang tidak dikenal"},
+		{Field: "stock", Rule: "number", Code: "invalid", Message: "stock harus berupa angka yang valid"},
+	}, err.(*domain.Error).Details)
+}
+
+func TestRowErrorMessage(t *testing.T) {
+	assert.Equal(t, "Voucher with SKU GV-100 already exists", rowErrorMessage(&domain.DuplicateSkuError{Sku: "GV-100"}))
+	assert.Equal(t, "Voucher could not be stored", rowErrorMessage(errors.New("connection refused")))
+}
@@ -0,0 +1,115 @@
+package voucher
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go-multiple-query/internal/domain"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportBatchSize is the number of vouchers fetched per cursor round trip
+// during an export.
+const exportBatchSize = 1000
+
+// exportContentTypes are the supported export formats and their media types.
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportFields are the json names of all voucher fields in declaration
+// order, the CSV columns when no fields are requested.
+var exportFields = func() []string {
+	var fields []string
+	typeOfVoucher := reflect.TypeOf(domain.Voucher{})
+	for i := 0; i < typeOfVoucher.NumField(); i++ {
+		name, _, _ := strings.Cut(typeOfVoucher.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}()
+
+// exportWriter encodes exported vouchers one at a time.
+type exportWriter interface {
+	Write(voucher *domain.Voucher) error
+	Flush() error
+}
+
+// newExportWriter returns the writer for format, writing only the given json
+// fields of every voucher, or all of them when fields is empty.
+func newExportWriter(format string, w io.Writer, fields []string) (exportWriter, error) {
+	switch format {
+	case "csv":
+		if len(fields) == 0 {
+			fields = exportFields
+		}
+		writer := &csvExportWriter{writer: csv.NewWriter(w), fields: fields}
+		return writer, writer.writer.Write(fields)
+	case "ndjson":
+		return &ndjsonExportWriter{encoder: json.NewEncoder(w), fields: fields}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %s", format)
+}
+
+// csvExportWriter writes a header row followed by a row per voucher.
+type csvExportWriter struct {
+	writer *csv.Writer
+	fields []string
+}
+
+func (e *csvExportWriter) Write(voucher *domain.Voucher) error {
+	v := reflect.ValueOf(voucher).Elem()
+
+	record := make([]string, 0, len(e.fields))
+	for _, field := range e.fields {
+		record = append(record, csvValue(v.Field(voucherJSONFields[field]).Interface()))
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExportWriter) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// ndjsonExportWriter writes a JSON object per line.
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+	fields  []string
+}
+
+func (e *ndjsonExportWriter) Write(voucher *domain.Voucher) error {
+	if len(e.fields) == 0 {
+		return e.encoder.Encode(voucher)
+	}
+	return e.encoder.Encode(trimVouchers([]*domain.Voucher{voucher}, e.fields)[0])
+}
+
+func (e *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+// csvValue formats a voucher field value as a CSV cell.
+func csvValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case primitive.ObjectID:
+		return value.Hex()
+	case *time.Time:
+		if value == nil {
+			return ""
+		}
+		return value.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,49 @@
+package voucher
+
+import (
+	"bytes"
+	"go-multiple-query/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewExportWriter_CSV(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4b")
+	var buf bytes.Buffer
+
+	writer, err := newExportWriter("csv", &buf, []string{"id", "sku_name", "stock"})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(&domain.Voucher{Id: id, SkuName: "Alfamart, 25K", Stock: 3}))
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, "id,sku_name,stock\n65a1b2c3d4e5f60718293a4b,\"Alfamart, 25K\",3\n", buf.String())
+}
+
+func TestNewExportWriter_CSVAllFields(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer, err := newExportWriter("csv", &buf, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, "id,brand_code,sku,sku_name,nominal,distributor_price,product_status,order_destination,stock,vendor,deleted_at\n", buf.String())
+}
+
+func TestNewExportWriter_NDJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer, err := newExportWriter("ndjson", &buf, []string{"sku", "stock"})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Write(&domain.Voucher{Sku: "ALFM25", Stock: 3}))
+	assert.NoError(t, writer.Write(&domain.Voucher{Sku: "IDMR50"}))
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, "{\"sku\":\"ALFM25\",\"stock\":3}\n{\"sku\":\"IDMR50\",\"stock\":0}\n", buf.String())
+}
+
+func TestNewExportWriter_UnsupportedFormat(t *testing.T) {
+	_, err := newExportWriter("xlsx", &bytes.Buffer{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// operatorParamPattern matches field[operator] query parameters.
+var operatorParamPattern = regexp.MustCompile(`^(\w+)\[(\w+)\]$`)
+
+// Pagination used when the page or size query parameter is missing.
+const (
+	defaultPage     = 1
+	defaultPageSize = 10
+)
+
+// filterDefaults are used for the sorting parameters missing from the query
+// string.
+var filterDefaults = map[string]string{
+	"order_by":   "sku_name",
+	"sort_order": "asc",
+}
+
+// voucherJSONFields maps the json name of every voucher field to its index in
+// domain.Voucher.
+var voucherJSONFields = jsonFieldIndexes(reflect.TypeOf(domain.Voucher{}))
+
+// queryValues copies the request query string into url.Values.
+func queryValues(c *fiber.Ctx) url.Values {
+	values := url.Values{}
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		values.Add(string(key), string(value))
+	})
+	return values
+}
+
+// parseFilter turns query parameters into a domain.VoucherFilter. Unknown
+// fields, unsupported operators and values of the wrong type are reported
+// together in a domain.InvalidFilterError. The page and size are left at
+// their defaults, see applyPageQuery.
+func parseFilter(values url.Values) (domain.VoucherFilter, error) {
+	var invalidParams []domain.InvalidFilterParam
+	reject := func(name, rule, param string) {
+		invalidParams = append(invalidParams, domain.InvalidFilterParam{Name: name, Rule: rule, Param: param})
+	}
+
+	filter := domain.VoucherFilter{
+		Search:    values.Get("q"),
+		Page:      defaultPage,
+		Size:      defaultPageSize,
+		Cursor:    values.Get("cursor"),
+		WithTotal: true,
+	}
+	if withTotal := values.Get("with_total"); withTotal != "" {
+		parsed, err := strconv.ParseBool(withTotal)
+		if err != nil {
+			reject("with_total", "boolean", "")
+		} else {
+			filter.WithTotal = parsed
+		}
+	}
+
+	if includeDeleted := values.Get("include_deleted"); includeDeleted != "" {
+		parsed, err := strconv.ParseBool(includeDeleted)
+		if err != nil {
+			reject("include_deleted", "boolean", "")
+		} else {
+			filter.IncludeDeleted = parsed
+		}
+	}
+
+	for _, field := range splitFilterValues(values["fields"]) {
+		if _, ok := voucherJSONFields[field]; !ok {
+			reject("fields", "unknown_field", field)
+			continue
+		}
+		filter.Fields = append(filter.Fields, field)
+	}
+
+	sortFields, sortParams := parseSort(filterParam(values, "order_by"), filterParam(values, "sort_order"))
+	filter.Sort = sortFields
+	invalidParams = append(invalidParams, sortParams...)
+
+	// Sort the keys so conditions are built in a stable order
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, operator := key, domain.FilterOperator("")
+		if matches := operatorParamPattern.FindStringSubmatch(key); matches != nil {
+			name, operator = matches[1], domain.FilterOperator(matches[2])
+		}
+
+		field, known := domain.VoucherFilterFields[name]
+		switch {
+		case !known && operator == "":
+			// Pagination, sorting and unrelated parameters
+			continue
+		case !known:
+			reject(key, "filterable", "")
+			continue
+		case operator == "":
+			condition, ok, err := parseEqualCondition(name, field, values[key])
+			if err != nil {
+				reject(key, "number", "")
+			} else if ok {
+				filter.Conditions = append(filter.Conditions, condition)
+			}
+			continue
+		case !operator.IsValid():
+			reject(key, "operator", "")
+			continue
+		case operator.IsRange() && field.Type != domain.FieldInt:
+			reject(key, "numeric_only", "")
+			continue
+		case operator.IsText() && field.Type != domain.FieldString:
+			reject(key, "text_only", "")
+			continue
+		}
+
+		condition := domain.FilterCondition{Field: name, Operator: operator}
+		var err error
+		switch {
+		case operator.IsText():
+			condition.Value = lastValue(values[key])
+		case operator.IsList():
+			condition.Value, err = coerceFilterValues(field.Type, splitFilterValues(values[key]))
+		default:
+			condition.Value, err = coerceFilterValue(field.Type, lastValue(values[key]))
+		}
+		if err != nil {
+			reject(key, "number", "")
+			continue
+		}
+		filter.Conditions = append(filter.Conditions, condition)
+	}
+
+	if len(invalidParams) > 0 {
+		sort.SliceStable(invalidParams, func(i, j int) bool {
+			return invalidParams[i].Name < invalidParams[j].Name
+		})
+		return domain.VoucherFilter{}, &domain.InvalidFilterError{Params: invalidParams}
+	}
+
+	return filter, nil
+}
+
+// applyPageQuery sets the page and size of a validated pagination query on
+// filter, keeping the defaults for the missing ones.
+func applyPageQuery(filter *domain.VoucherFilter, query *domain.PageQuery) {
+	filter.Page, filter.Size = query.Resolve(filter.Page, filter.Size)
+}
+
+// parseEqualCondition builds the condition for a plain field=value parameter.
+// Multi fields with several values become an in condition. Empty values are
+// ignored, reported by ok being false.
+func parseEqualCondition(name string, field domain.FilterField, raw []string) (domain.FilterCondition, bool, error) {
+	if field.Multi {
+		raw = splitFilterValues(raw)
+	} else if value := lastValue(raw); value != "" {
+		raw = []string{value}
+	} else {
+		raw = nil
+	}
+	if len(raw) == 0 {
+		return domain.FilterCondition{}, false, nil
+	}
+
+	values, err := coerceFilterValues(field.Type, raw)
+	if err != nil {
+		return domain.FilterCondition{}, false, err
+	}
+
+	if len(values) == 1 {
+		return domain.FilterCondition{Field: name, Operator: domain.FilterEq, Value: values[0]}, true, nil
+	}
+	return domain.FilterCondition{Field: name, Operator: domain.FilterIn, Value: values}, true, nil
+}
+
+// parseSort converts a comma separated order_by into sort fields. Keys
+// prefixed with "-" sort descending, other keys follow sort_order.
+func parseSort(orderBy, sortOrder string) ([]domain.SortField, []domain.InvalidFilterParam) {
+	var invalidParams []domain.InvalidFilterParam
+
+	defaultDesc := false
+	switch sortOrder {
+	case "", "asc":
+	case "desc":
+		defaultDesc = true
+	default:
+		invalidParams = append(invalidParams, domain.InvalidFilterParam{Name: "sort_order", Rule: "oneof", Param: "asc desc"})
+	}
+
+	var sortFields []domain.SortField
+	seen := map[string]bool{}
+	for _, key := range splitFilterValues([]string{orderBy}) {
+		desc := defaultDesc
+		if strings.HasPrefix(key, "-") {
+			key, desc = key[1:], true
+		}
+
+		if _, ok := domain.VoucherFilterFields[key]; !ok {
+			invalidParams = append(invalidParams, domain.InvalidFilterParam{Name: "order_by", Rule: "unknown_field", Param: key})
+			continue
+		}
+		if seen[key] {
+			invalidParams = append(invalidParams, domain.InvalidFilterParam{Name: "order_by", Rule: "repeated_field", Param: key})
+			continue
+		}
+
+		seen[key] = true
+		sortFields = append(sortFields, domain.SortField{Field: key, Desc: desc})
+	}
+
+	return sortFields, invalidParams
+}
+
+// filterParam returns a query parameter or its default when missing.
+func filterParam(values url.Values, key string) string {
+	if value := values.Get(key); value != "" {
+		return value
+	}
+	return filterDefaults[key]
+}
+
+// coerceFilterValues converts every raw query value to the field type.
+func coerceFilterValues(fieldType domain.FieldType, raw []string) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(raw))
+	for _, str := range raw {
+		value, err := coerceFilterValue(fieldType, str)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// coerceFilterValue converts a raw query value to the field type.
+func coerceFilterValue(fieldType domain.FieldType, str string) (interface{}, error) {
+	if fieldType == domain.FieldInt {
+		return strconv.Atoi(str)
+	}
+	return str, nil
+}
+
+// splitFilterValues flattens repeated and comma separated query values.
+func splitFilterValues(raw []string) []string {
+	var values []string
+	for _, item := range raw {
+		for _, value := range strings.Split(item, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func lastValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// trimVouchers keeps only the requested json fields of every voucher.
+func trimVouchers(vouchers []*domain.Voucher, fields []string) []map[string]interface{} {
+	trimmed := make([]map[string]interface{}, 0, len(vouchers))
+	for _, voucher := range vouchers {
+		v := reflect.ValueOf(voucher).Elem()
+
+		item := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			item[field] = v.Field(voucherJSONFields[field]).Interface()
+		}
+		trimmed = append(trimmed, item)
+	}
+	return trimmed
+}
@@ -0,0 +1,107 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter_Defaults(t *testing.T) {
+	filter, err := parseFilter(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.VoucherFilter{
+		Sort:      []domain.SortField{{Field: "sku_name"}},
+		Page:      1,
+		Size:      10,
+		WithTotal: true,
+	}, filter)
+}
+
+func TestParseFilter_Conditions(t *testing.T) {
+	values, _ := url.ParseQuery("brand_code=ALFM,IDMR&vendor=A&stock=76&nominal[gte]=10000&sku[not_in]=ALFM25" +
+		"&sku_name[prefix]=Voucher&product_status[ne]=unavailable&q=alfamart&page=2&size=20&with_total=false")
+
+	filter, err := parseFilter(values)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.FilterCondition{
+		{Field: "brand_code", Operator: domain.FilterIn, Value: []interface{}{"ALFM", "IDMR"}},
+		{Field: "nominal", Operator: domain.FilterGte, Value: 10000},
+		{Field: "product_status", Operator: domain.FilterNe, Value: "unavailable"},
+		{Field: "sku", Operator: domain.FilterNotIn, Value: []interface{}{"ALFM25"}},
+		{Field: "sku_name", Operator: domain.FilterPrefix, Value: "Voucher"},
+		{Field: "stock", Operator: domain.FilterEq, Value: 76},
+		{Field: "vendor", Operator: domain.FilterEq, Value: "A"},
+	}, filter.Conditions)
+	assert.Equal(t, "alfamart", filter.Search)
+	assert.False(t, filter.WithTotal)
+}
+
+func TestApplyPageQuery(t *testing.T) {
+	filter, err := parseFilter(url.Values{})
+	assert.NoError(t, err)
+
+	applyPageQuery(&filter, &domain.PageQuery{Size: "20"})
+	assert.Equal(t, 1, filter.Page)
+	assert.Equal(t, 20, filter.Size)
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	values, _ := url.ParseQuery("stock=many&distributor_price[gt]=12k&nominal[like]=1&sku_name[gt]=A" +
+		"&nominal[contains]=15&unknown[ne]=x&with_total=maybe")
+
+	_, err := parseFilter(values)
+
+	var filterErr *domain.InvalidFilterError
+	assert.ErrorAs(t, err, &filterErr)
+	assert.Equal(t, []domain.InvalidFilterParam{
+		{Name: "distributor_price[gt]", Rule: "number"},
+		{Name: "nominal[contains]", Rule: "text_only"},
+		{Name: "nominal[like]", Rule: "operator"},
+		{Name: "sku_name[gt]", Rule: "numeric_only"},
+		{Name: "stock", Rule: "number"},
+		{Name: "unknown[ne]", Rule: "filterable"},
+		{Name: "with_total", Rule: "boolean"},
+	}, filterErr.Params)
+}
+
+func TestParseSort_MultiKey(t *testing.T) {
+	sortFields, invalidParams := parseSort("nominal,-distributor_price", "desc")
+	assert.Empty(t, invalidParams)
+	assert.Equal(t, []domain.SortField{
+		{Field: "nominal", Desc: true},
+		{Field: "distributor_price", Desc: true},
+	}, sortFields)
+}
+
+func TestParseSort_UnknownField(t *testing.T) {
+	_, invalidParams := parseSort("nominal,password,-nominal", "sideways")
+	assert.Equal(t, []domain.InvalidFilterParam{
+		{Name: "sort_order", Rule: "oneof", Param: "asc desc"},
+		{Name: "order_by", Rule: "unknown_field", Param: "password"},
+		{Name: "order_by", Rule: "repeated_field", Param: "nominal"},
+	}, invalidParams)
+}
+
+func TestParseFilter_Fields(t *testing.T) {
+	values, _ := url.ParseQuery("fields=sku,sku_name,nominal")
+	filter, err := parseFilter(values)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sku", "sku_name", "nominal"}, filter.Fields)
+
+	values, _ = url.ParseQuery("fields=sku,password")
+	_, err = parseFilter(values)
+
+	var filterErr *domain.InvalidFilterError
+	assert.ErrorAs(t, err, &filterErr)
+	assert.Equal(t, []domain.InvalidFilterParam{{Name: "fields", Rule: "unknown_field", Param: "password"}}, filterErr.Params)
+}
+
+func TestTrimVouchers(t *testing.T) {
+	vouchers := []*domain.Voucher{{Sku: "ALFM25", SkuName: "Voucher Alfamart 25k", Nominal: 25000, Stock: 76}}
+
+	assert.Equal(t, []map[string]interface{}{
+		{"sku": "ALFM25", "nominal": 25000},
+	}, trimVouchers(vouchers, []string{"sku", "nominal"}))
+}
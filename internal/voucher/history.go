@@ -0,0 +1,32 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"reflect"
+	"time"
+)
+
+// historyEntries lists the recorded field changes between two versions of a
+// voucher. A nil before records the values the voucher was created with.
+func historyEntries(actor domain.Actor, before, after *domain.Voucher, changedAt time.Time) []*domain.VoucherHistory {
+	var entries []*domain.VoucherHistory
+	afterValue := reflect.ValueOf(after).Elem()
+	for _, field := range domain.VoucherHistoryFields {
+		entry := &domain.VoucherHistory{
+			VoucherId: after.Id,
+			Field:     field,
+			New:       afterValue.Field(voucherJSONFields[field]).Interface(),
+			Actor:     actor.Name,
+			RequestId: actor.RequestId,
+			ChangedAt: changedAt,
+		}
+		if before != nil {
+			entry.Old = reflect.ValueOf(before).Elem().Field(voucherJSONFields[field]).Interface()
+			if entry.Old == entry.New {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,38 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHistoryEntries_Created(t *testing.T) {
+	actor := domain.Actor{Name: "finance", RequestId: "req-1"}
+	voucher := &domain.Voucher{Id: primitive.NewObjectID(), DistributorPrice: 24000, Stock: 5, ProductStatus: domain.ProductStatusAvailable}
+
+	entries := historyEntries(actor, nil, voucher, time.Now())
+	assert.Len(t, entries, 3)
+	assert.Nil(t, entries[0].Old)
+	assert.Equal(t, 24000, entries[0].New)
+	assert.Equal(t, "req-1", entries[0].RequestId)
+}
+
+func TestHistoryEntries_Changed(t *testing.T) {
+	before := &domain.Voucher{Id: primitive.NewObjectID(), SkuName: "Alfamart 25K", DistributorPrice: 24000, Stock: 1, ProductStatus: domain.ProductStatusAvailable}
+	after := *before
+	after.SkuName = "Alfamart 25.000"
+	after.Stock = 0
+	after.ProductStatus = domain.ProductStatusOutOfStock
+
+	entries := historyEntries(domain.Actor{Name: domain.SystemActor}, before, &after, time.Now())
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "stock", entries[0].Field)
+	assert.Equal(t, 1, entries[0].Old)
+	assert.Equal(t, 0, entries[0].New)
+	assert.Equal(t, "product_status", entries[1].Field)
+	assert.Equal(t, domain.ProductStatusOutOfStock, entries[1].New)
+	assert.Equal(t, domain.SystemActor, entries[1].Actor)
+}
@@ -1,54 +1,63 @@
 package voucher
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"go-multiple-query/internal/domain"
 	"go-multiple-query/internal/middleware/validation"
 	"go-multiple-query/internal/utilities"
 	"math"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type httpHandler struct {
 	voucherService domain.VoucherService
+	logger         *zerolog.Logger
+	exportTimeout  time.Duration
 }
 
-// NewHTTPHandler creates a new instance of HTTPHandler.
-func NewHTTPHandler(r fiber.Router, voucherService domain.VoucherService, logger *zerolog.Logger) {
+// NewHTTPHandler creates a new instance of HTTPHandler. Exports run for at
+// most exportTimeout, they outlast the timeout of the request.
+func NewHTTPHandler(r fiber.Router, voucherService domain.VoucherService, logger *zerolog.Logger, exportTimeout time.Duration) {
 	handler := &httpHandler{
 		voucherService: voucherService,
+		logger:         logger,
+		exportTimeout:  exportTimeout,
 	}
 
 	r.Post("/", validation.New[domain.StoreVoucherRequest](), handler.Store)
-	r.Get("/filter", handler.FindWithFilter)
+	r.Post("/bulk", handler.Bulk)
+	r.Post("/import", handler.Import)
+	r.Get("/filter", validation.NewQuery[domain.PageQuery](), handler.FindWithFilter)
+	r.Get("/aggregate", handler.Aggregate)
+	r.Get("/export", handler.Export)
+	r.Get("/:id", handler.FindByID)
+	r.Get("/:id/history", validation.NewQuery[domain.PageQuery](), handler.FindHistory)
+	r.Put("/:id", validation.New[domain.StoreVoucherRequest](), handler.Update)
+	r.Patch("/:id", validation.NewMergePatch[domain.StoreVoucherRequest](), handler.Patch)
+	r.Delete("/:id", handler.Delete)
+	r.Post("/:id/restore", handler.Restore)
+	r.Post("/:id/reserve", validation.New[domain.ReserveStockRequest](), handler.Reserve)
+	r.Post("/:id/release", validation.New[domain.ReleaseStockRequest](), handler.Release)
+	r.Post("/:id/status", validation.New[domain.ChangeProductStatusRequest](), handler.ChangeStatus)
 }
 
 // Store handles the store voucher request.
 func (h *httpHandler) Store(c *fiber.Ctx) error {
 	storeVoucherReq := utilities.ExtractStructFromValidator[domain.StoreVoucherRequest](c)
 
-	voucher := domain.Voucher{
-		BrandCode:        storeVoucherReq.BrandCode,
-		Sku:              storeVoucherReq.Sku,
-		SkuName:          storeVoucherReq.SkuName,
-		Nominal:          storeVoucherReq.Nominal,
-		DistributorPrice: storeVoucherReq.DistributorPrice,
-		ProductStatus:    storeVoucherReq.ProductStatus,
-		OrderDestination: storeVoucherReq.OrderDestination,
-		Stock:            storeVoucherReq.Stock,
-		Vendor:           storeVoucherReq.Vendor,
-	}
+	voucher := voucherFromRequest(storeVoucherReq)
 
-	result, err := h.voucherService.Store(&voucher)
+	result, err := h.voucherService.Store(c.UserContext(), utilities.ActorFromRequest(c), &voucher)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(domain.Response{
-			Code:    fiber.StatusInternalServerError,
-			Status:  "error",
-			Message: err.Error(),
-		})
+		return err
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(domain.Response{
@@ -59,65 +68,482 @@ func (h *httpHandler) Store(c *fiber.Ctx) error {
 	})
 }
 
-// FindWithFilter handles the find with filter request.
-func (h *httpHandler) FindWithFilter(c *fiber.Ctx) error {
-	filter := new(domain.VoucherFilter)
+// Bulk handles the bulk store voucher request. Every row is validated on its
+// own and reported as created, invalid, duplicate, skipped or failed. With
+// ordered=true the insert stops at the first row the database rejects.
+func (h *httpHandler) Bulk(c *fiber.Ctx) error {
+	ordered, err := strconv.ParseBool(c.Query("ordered", "false"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "ordered must be a boolean")
+	}
+
+	rows, err := decodeBulkRows(c.Get(fiber.HeaderContentType), c.Body())
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "request body must be a JSON array or NDJSON")
+	}
+	if len(rows) > maxBulkVouchers {
+		return fiber.NewError(fiber.StatusBadRequest, "at most "+strconv.Itoa(maxBulkVouchers)+" vouchers are allowed per request")
+	}
+
+	trans := validation.Translator(c)
+	report := &domain.BulkVoucherReport{Results: make([]*domain.BulkVoucherResult, 0, len(rows))}
+	var vouchers []*domain.Voucher
+	var pending []*domain.BulkVoucherResult
+	for i, row := range rows {
+		result := &domain.BulkVoucherResult{Row: i + 1}
+		report.Results = append(report.Results, result)
+
+		if row.err != nil {
+			result.Status = domain.BulkStatusInvalid
+			if fieldErr, ok := validation.DecodeError(trans, row.err); ok {
+				result.FieldErrors = []domain.FieldError{fieldErr}
+			} else {
+				result.Errors = []string{"Voucher is not a valid JSON object"}
+			}
+			continue
+		}
+
+		result.Sku = row.request.Sku
+		if errs := validation.Validate(row.request, trans); len(errs) > 0 {
+			result.Status = domain.BulkStatusInvalid
+			result.FieldErrors = errs
+			continue
+		}
 
-	if err := c.QueryParser(filter); err != nil {
+		voucher := voucherFromRequest(row.request)
+		vouchers = append(vouchers, &voucher)
+		pending = append(pending, result)
+	}
+
+	errs, err := h.voucherService.StoreMany(c.UserContext(), utilities.ActorFromRequest(c), vouchers, ordered)
+	if err != nil {
 		return err
 	}
 
-	defaults := map[string]*string{
-		"1":        &filter.Page,
-		"10":       &filter.Size,
-		"sku_name": &filter.OrderBy,
-		"asc":      &filter.SortOrder,
+	for i, result := range pending {
+		var duplicateErr *domain.DuplicateSkuError
+		switch {
+		case errs[i] == nil:
+			result.Status = domain.BulkStatusCreated
+			result.Id = &vouchers[i].Id
+			result.Changes = createdChanges(requestFromVoucher(vouchers[i]))
+		case errors.As(errs[i], &duplicateErr):
+			result.Status = domain.BulkStatusDuplicate
+			result.Errors = []string{rowErrorMessage(duplicateErr)}
+		case errors.Is(errs[i], domain.ErrBulkSkipped):
+			result.Status = domain.BulkStatusSkipped
+			result.Errors = []string{errs[i].Error()}
+		default:
+			result.Status = domain.BulkStatusFailed
+			result.Errors = []string{rowErrorMessage(errs[i])}
+			h.logger.Error().Err(errs[i]).Int("row", result.Row).Msg("Bulk voucher could not be stored")
+		}
 	}
 
-	for defaultValue, field := range defaults {
-		if *field == "" {
-			*field = defaultValue
+	for _, result := range report.Results {
+		if result.Status == domain.BulkStatusCreated {
+			report.Created++
+		} else {
+			report.Failed++
 		}
 	}
 
-	vouchers, nextPage, err := h.voucherService.FindWithFilter(*filter)
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Bulk vouchers have been processed",
+		Data:    report,
+	})
+}
+
+// Import upserts vouchers from an uploaded CSV price list. The optional
+// mapping form value maps CSV headers to voucher fields, other form values
+// named after a voucher field set that field on every row.
+func (h *httpHandler) Import(c *fiber.Ctx) error {
+	dryRun, err := strconv.ParseBool(c.Query("dry_run", "false"))
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(domain.Response{
-				Code:    fiber.StatusNotFound,
-				Status:  "error",
-				Message: "Vouchers not found",
-			})
+		return fiber.NewError(fiber.StatusBadRequest, "dry_run must be a boolean")
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "price list must be uploaded as the file form field")
+	}
+	file := form.File["file"][0]
+	if !isCSVUpload(file.Header.Get(fiber.HeaderContentType), file.Filename) {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "price list must be a text/csv file")
+	}
+
+	mapping := map[string]string{}
+	if raw := lastValue(form.Value["mapping"]); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "mapping must be a JSON object of header to field")
+		}
+	}
+
+	defaults := map[string]string{}
+	for key, values := range form.Value {
+		if _, ok := requestJSONFields[key]; ok && lastValue(values) != "" {
+			defaults[key] = lastValue(values)
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(domain.Response{
-			Code:    fiber.StatusInternalServerError,
-			Status:  "error",
-			Message: err.Error(),
-		})
 	}
 
-	totalItem, err := h.voucherService.Count(*filter)
+	content, err := file.Open()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	defer content.Close()
+
+	rows, err := parsePriceList(content, mapping, defaults, validation.Translator(c))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	report, err := h.voucherService.Import(c.UserContext(), utilities.ActorFromRequest(c), rows, dryRun)
+	if err != nil {
+		return err
+	}
+
+	message := "Price list has been imported"
+	if dryRun {
+		message = "Price list import has been previewed"
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: message,
+		Data:    report,
+	})
+}
+
+// FindByID handles the get voucher request.
+func (h *httpHandler) FindByID(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	voucher, err := h.voucherService.FindByID(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher has been fetched successfully",
+		Data:    voucher,
+	})
+}
+
+// FindHistory handles the voucher price, stock and status history request.
+func (h *httpHandler) FindHistory(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(domain.Response{
-			Code:    fiber.StatusInternalServerError,
-			Status:  "error",
-			Message: err.Error(),
-		})
+		return errVoucherNotFound
 	}
 
-	size, _ := strconv.Atoi(filter.Size)
-	maxPage := int(math.Ceil(float64(totalItem) / float64(size)))
+	query := utilities.ExtractStructFromValidator[domain.PageQuery](c)
+	page, size := query.Resolve(defaultPage, defaultPageSize)
 
-	if nextPage > 0 && nextPage <= maxPage {
-		c.Set("X-Cursor", strconv.Itoa(nextPage))
+	result, err := h.voucherService.FindHistory(c.UserContext(), id, page, size)
+	if err != nil {
+		return err
 	}
-	c.Set("X-Total-Count", strconv.Itoa(int(totalItem)))
+
+	maxPage := int(math.Ceil(float64(result.Total) / float64(size)))
+	c.Set("X-Total-Count", strconv.Itoa(int(result.Total)))
 	c.Set("X-Max-Page", strconv.Itoa(maxPage))
 
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher history has been fetched successfully",
+		Data:    result.History,
+	})
+}
+
+// Update handles the replace voucher request.
+func (h *httpHandler) Update(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	storeVoucherReq := utilities.ExtractStructFromValidator[domain.StoreVoucherRequest](c)
+
+	voucher := voucherFromRequest(storeVoucherReq)
+
+	result, err := h.voucherService.Update(c.UserContext(), utilities.ActorFromRequest(c), id, &voucher)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher has been updated successfully",
+		Data:    result,
+	})
+}
+
+// Patch handles the JSON Merge Patch voucher request.
+func (h *httpHandler) Patch(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	patch := utilities.ExtractStructFromValidator[map[string]json.RawMessage](c)
+
+	result, err := h.voucherService.Patch(c.UserContext(), utilities.ActorFromRequest(c), id, *patch)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher has been updated successfully",
+		Data:    result,
+	})
+}
+
+// Delete handles the delete voucher request.
+func (h *httpHandler) Delete(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	if err := h.voucherService.Delete(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher has been deleted successfully",
+	})
+}
+
+// Restore handles the restore archived voucher request.
+func (h *httpHandler) Restore(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	result, err := h.voucherService.Restore(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher has been restored successfully",
+		Data:    result,
+	})
+}
+
+// Reserve handles the reserve voucher stock request.
+func (h *httpHandler) Reserve(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	reserveReq := utilities.ExtractStructFromValidator[domain.ReserveStockRequest](c)
+
+	result, err := h.voucherService.Reserve(c.UserContext(), utilities.ActorFromRequest(c), id, reserveReq.Quantity)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(domain.Response{
+		Code:    fiber.StatusCreated,
+		Status:  "success",
+		Message: "Voucher stock has been reserved successfully",
+		Data:    result,
+	})
+}
+
+// Release handles the release reserved voucher stock request.
+func (h *httpHandler) Release(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	releaseReq := utilities.ExtractStructFromValidator[domain.ReleaseStockRequest](c)
+
+	reservationId, err := primitive.ObjectIDFromHex(releaseReq.ReservationId)
+	if err != nil {
+		return errReservationNotFound
+	}
+
+	result, err := h.voucherService.Release(c.UserContext(), utilities.ActorFromRequest(c), id, reservationId, releaseReq.Quantity)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher stock has been released successfully",
+		Data:    result,
+	})
+}
+
+// ChangeStatus handles the change voucher product status request.
+func (h *httpHandler) ChangeStatus(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return errVoucherNotFound
+	}
+
+	statusReq := utilities.ExtractStructFromValidator[domain.ChangeProductStatusRequest](c)
+
+	result, err := h.voucherService.ChangeStatus(c.UserContext(), utilities.ActorFromRequest(c), id, statusReq.Status)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher status has been changed successfully",
+		Data:    result,
+	})
+}
+
+// FindWithFilter handles the find with filter request.
+func (h *httpHandler) FindWithFilter(c *fiber.Ctx) error {
+	filter, err := parseFilter(queryValues(c))
+	if err != nil {
+		return filterError(c, err)
+	}
+	applyPageQuery(&filter, utilities.ExtractStructFromValidator[domain.PageQuery](c))
+
+	page, err := h.voucherService.FindWithFilter(c.UserContext(), filter)
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	if page.NextPage > 0 {
+		c.Set("X-Cursor", strconv.Itoa(page.NextPage))
+	}
+	if page.NextCursor != "" {
+		c.Set("X-Next-Cursor", page.NextCursor)
+	}
+
+	// The total is skipped with with_total=false
+	if page.Total != nil {
+		maxPage := int(math.Ceil(float64(*page.Total) / float64(filter.Size)))
+
+		c.Set("X-Total-Count", strconv.Itoa(int(*page.Total)))
+		c.Set("X-Max-Page", strconv.Itoa(maxPage))
+	}
+
+	var data interface{} = page.Vouchers
+	if len(filter.Fields) > 0 {
+		data = trimVouchers(page.Vouchers, filter.Fields)
+	}
+
 	return c.JSON(domain.Response{
 		Code:    fiber.StatusOK,
 		Status:  "success",
 		Message: "Vouchers have been fetched successfully",
-		Data:    vouchers,
+		Data:    data,
 	})
 }
+
+// Export streams every voucher matching the filter parameters as CSV or
+// NDJSON. Rows are written as they are read from the database, so the
+// response is never held in memory as a whole.
+func (h *httpHandler) Export(c *fiber.Ctx) error {
+	values := queryValues(c)
+
+	format := values.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "format must be csv or ndjson")
+	}
+
+	filter, err := parseFilter(values)
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="vouchers.`+format+`"`)
+
+	// The rows are written once the handler has returned, past the request
+	// timeout, so the export gets a timeout of its own. A client that goes
+	// away stops it through a failed write. The status is sent before the
+	// first row, errors past this point can only cut the export short
+	requestCtx := c.UserContext()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(requestCtx), h.exportTimeout)
+		defer cancel()
+
+		writer, err := newExportWriter(format, w, filter.Fields)
+		if err == nil {
+			err = h.voucherService.Export(ctx, filter, writer.Write)
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Voucher export was interrupted")
+		}
+	})
+
+	return nil
+}
+
+// Aggregate handles the voucher statistics request.
+func (h *httpHandler) Aggregate(c *fiber.Ctx) error {
+	values := queryValues(c)
+
+	filter, err := parseFilter(values)
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	// group_by is checked along with the pipeline, see buildAggregatePipeline
+	stats, err := h.voucherService.Aggregate(c.UserContext(), filter, values.Get("group_by"))
+	if err != nil {
+		return filterError(c, err)
+	}
+
+	return c.JSON(domain.Response{
+		Code:    fiber.StatusOK,
+		Status:  "success",
+		Message: "Voucher statistics have been fetched successfully",
+		Data:    stats,
+	})
+}
+
+// voucherFromRequest maps a store voucher request to a voucher.
+func voucherFromRequest(req *domain.StoreVoucherRequest) domain.Voucher {
+	return domain.Voucher{
+		BrandCode:        req.BrandCode,
+		Sku:              req.Sku,
+		SkuName:          req.SkuName,
+		Nominal:          req.Nominal,
+		DistributorPrice: req.DistributorPrice,
+		ProductStatus:    req.ProductStatus,
+		OrderDestination: req.OrderDestination,
+		Stock:            req.Stock,
+		Vendor:           req.Vendor,
+	}
+}
@@ -0,0 +1,243 @@
+package voucher
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/middleware/validation"
+	"io"
+	"mime"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+)
+
+// requestJSONFields maps the json name of every store voucher request field
+// to its index in domain.StoreVoucherRequest.
+var requestJSONFields = jsonFieldIndexes(reflect.TypeOf(domain.StoreVoucherRequest{}))
+
+// requestFields lists the json names of the store voucher request fields in
+// declaration order, the fields a replace writes.
+var requestFields = jsonFieldNames(reflect.TypeOf(domain.StoreVoucherRequest{}))
+
+// isCSVUpload reports whether an uploaded file is a CSV file, going by its
+// content type or, for clients that send a generic one, its extension.
+func isCSVUpload(contentType, filename string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return true
+	case "", "application/octet-stream", "application/vnd.ms-excel":
+		return strings.EqualFold(filepath.Ext(filename), ".csv")
+	}
+	return false
+}
+
+// parsePriceList reads a CSV price list into import rows. Columns map to
+// voucher fields through mapping, keyed by header, or by the header itself
+// when it is not mapped. Defaults fill the fields the file does not set. Row
+// errors are described with the messages of trans.
+func parsePriceList(r io.Reader, mapping, defaults map[string]string, trans ut.Translator) ([]*domain.VoucherImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("price list is empty")
+		}
+		return nil, fmt.Errorf("price list is not valid CSV: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	hasSku := false
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		field, ok := mapping[name]
+		if !ok {
+			field = strings.ReplaceAll(strings.ToLower(name), " ", "_")
+		}
+		if _, known := requestJSONFields[field]; !known {
+			return nil, fmt.Errorf("column %q does not map to a voucher field", name)
+		}
+		columns[i] = field
+		hasSku = hasSku || field == "sku"
+	}
+	if !hasSku {
+		return nil, errors.New("price list must have a sku column")
+	}
+
+	var rows []*domain.VoucherImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("price list is not valid CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, parsePriceListRecord(line, columns, record, defaults, trans))
+	}
+
+	return rows, nil
+}
+
+// parsePriceListRecord builds the import row of a single CSV record. Empty
+// cells leave the field unset.
+func parsePriceListRecord(line int, columns, record []string, defaults map[string]string, trans ut.Translator) *domain.VoucherImportRow {
+	row := &domain.VoucherImportRow{Line: line}
+
+	cells := map[string]string{}
+	for i, value := range record {
+		if i < len(columns) && strings.TrimSpace(value) != "" {
+			cells[columns[i]] = strings.TrimSpace(value)
+		}
+	}
+	for field, value := range defaults {
+		if _, ok := cells[field]; !ok {
+			cells[field] = value
+		}
+	}
+
+	values := map[string]interface{}{}
+	typeOfRequest := reflect.TypeOf(row.Request)
+	for _, field := range sortedFields(cells) {
+		value := cells[field]
+		if typeOfRequest.Field(requestJSONFields[field]).Type.Kind() == reflect.Int {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				row.Errors = append(row.Errors, validation.FieldError(trans, field, "number"))
+				continue
+			}
+			values[field] = number
+		} else {
+			values[field] = value
+		}
+		row.Fields = append(row.Fields, field)
+	}
+
+	body, _ := json.Marshal(values)
+	_ = json.Unmarshal(body, &row.Request)
+
+	if row.Request.Sku == "" {
+		row.Errors = append(row.Errors, validation.FieldError(trans, "sku", "required"))
+	}
+	row.Errors = append(row.Errors, validation.ValidatePartial(&row.Request, trans, row.Fields...)...)
+	row.CreateErrors = validation.Validate(row.Request, trans)
+
+	return row
+}
+
+// matchImportRow finds the stored voucher an import row updates. Rows that
+// set a vendor only match that vendor's voucher, other rows must match a
+// single voucher.
+func matchImportRow(existing []*domain.Voucher, row *domain.VoucherImportRow) (*domain.Voucher, error) {
+	setsVendor := false
+	for _, field := range row.Fields {
+		setsVendor = setsVendor || field == "vendor"
+	}
+
+	var match *domain.Voucher
+	for _, voucher := range existing {
+		if voucher.Sku != row.Request.Sku || (setsVendor && voucher.Vendor != row.Request.Vendor) {
+			continue
+		}
+		if match != nil {
+			return nil, errors.New("sku is used by several vendors, set the vendor to choose one")
+		}
+		match = voucher
+	}
+
+	return match, nil
+}
+
+// requestFromVoucher maps a voucher back to a store voucher request.
+func requestFromVoucher(voucher *domain.Voucher) domain.StoreVoucherRequest {
+	return domain.StoreVoucherRequest{
+		BrandCode:        voucher.BrandCode,
+		Sku:              voucher.Sku,
+		SkuName:          voucher.SkuName,
+		Nominal:          voucher.Nominal,
+		DistributorPrice: voucher.DistributorPrice,
+		ProductStatus:    voucher.ProductStatus,
+		OrderDestination: voucher.OrderDestination,
+		Stock:            voucher.Stock,
+		Vendor:           voucher.Vendor,
+	}
+}
+
+// applyRequestFields copies the given json fields from src to dst.
+func applyRequestFields(dst, src *domain.StoreVoucherRequest, fields []string) {
+	dstValue, srcValue := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for _, field := range fields {
+		index := requestJSONFields[field]
+		dstValue.Field(index).Set(srcValue.Field(index))
+	}
+}
+
+// diffRequests returns the fields whose value differs between two requests,
+// keyed by json name.
+func diffRequests(old, new domain.StoreVoucherRequest) map[string]domain.VoucherFieldChange {
+	changes := map[string]domain.VoucherFieldChange{}
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	for field, index := range requestJSONFields {
+		before, after := oldValue.Field(index).Interface(), newValue.Field(index).Interface()
+		if before != after {
+			changes[field] = domain.VoucherFieldChange{Old: before, New: after}
+		}
+	}
+	return changes
+}
+
+// createdChanges returns the fields of a created voucher as changes from no
+// value, keyed by json name.
+func createdChanges(request domain.StoreVoucherRequest) map[string]domain.VoucherFieldChange {
+	changes := map[string]domain.VoucherFieldChange{}
+	value := reflect.ValueOf(request)
+	for field, index := range requestJSONFields {
+		changes[field] = domain.VoucherFieldChange{New: value.Field(index).Interface()}
+	}
+	return changes
+}
+
+// jsonFieldNames returns the json names of the fields of a struct type.
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+// jsonFieldIndexes maps the json name of every field of a struct type to its
+// index.
+func jsonFieldIndexes(t reflect.Type) map[string]int {
+	fields := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = i
+	}
+	return fields
+}
+
+// sortedFields returns the keys of cells in field declaration order.
+func sortedFields(cells map[string]string) []string {
+	fields := make([]string, 0, len(cells))
+	for field := range cells {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return requestJSONFields[fields[i]] < requestJSONFields[fields[j]]
+	})
+	return fields
+}
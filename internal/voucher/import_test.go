@@ -0,0 +1,103 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"go-multiple-query/internal/middleware/validation"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var trans = validation.TranslatorFor("en")
+
+func TestParsePriceList_MissingSku(t *testing.T) {
+	rows, err := parsePriceList(strings.NewReader("sku,stock\n,10\n"), nil, nil, trans)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.FieldError{{Field: "sku", Rule: "required", Code: "required", Message: "sku is a required field"}}, rows[0].Errors)
+}
+
+func TestParsePriceList_Headers(t *testing.T) {
+	csv := "SKU,Distributor Price,Stock\nALFM25,24500,\nIDMR50,abc,10\n"
+	rows, err := parsePriceList(strings.NewReader(csv), map[string]string{}, map[string]string{}, trans)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, []string{"sku", "distributor_price"}, rows[0].Fields)
+	assert.Equal(t, 24500, rows[0].Request.DistributorPrice)
+	assert.Empty(t, rows[0].Errors)
+	assert.NotEmpty(t, rows[0].CreateErrors)
+
+	assert.Equal(t, 3, rows[1].Line)
+	assert.Len(t, rows[1].Errors, 1)
+	assert.Equal(t, "distributor_price", rows[1].Errors[0].Field)
+	assert.Equal(t, "distributor_price must be a valid number", rows[1].Errors[0].Message)
+}
+
+func TestParsePriceList_MappingAndDefaults(t *testing.T) {
+	csv := "kode,harga\nALFM25,24500\n"
+	mapping := map[string]string{"kode": "sku", "harga": "distributor_price"}
+	rows, err := parsePriceList(strings.NewReader(csv), mapping, map[string]string{"vendor": "alfamart"}, trans)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, "ALFM25", rows[0].Request.Sku)
+	assert.Equal(t, "alfamart", rows[0].Request.Vendor)
+	assert.Equal(t, []string{"sku", "distributor_price", "vendor"}, rows[0].Fields)
+}
+
+func TestParsePriceList_Invalid(t *testing.T) {
+	_, err := parsePriceList(strings.NewReader(""), nil, nil, trans)
+	assert.Error(t, err)
+
+	_, err = parsePriceList(strings.NewReader("sku,colour\nALFM25,red\n"), nil, nil, trans)
+	assert.EqualError(t, err, `column "colour" does not map to a voucher field`)
+
+	_, err = parsePriceList(strings.NewReader("stock\n10\n"), nil, nil, trans)
+	assert.EqualError(t, err, "price list must have a sku column")
+}
+
+func TestIsCSVUpload(t *testing.T) {
+	assert.True(t, isCSVUpload("text/csv; charset=utf-8", "prices.txt"))
+	assert.True(t, isCSVUpload("application/octet-stream", "prices.CSV"))
+	assert.False(t, isCSVUpload("application/octet-stream", "prices.xlsx"))
+	assert.False(t, isCSVUpload("application/json", "prices.csv"))
+}
+
+func TestMatchImportRow(t *testing.T) {
+	existing := []*domain.Voucher{
+		{Sku: "ALFM25", Vendor: "alfamart"},
+		{Sku: "ALFM25", Vendor: "indomaret"},
+		{Sku: "IDMR50", Vendor: "indomaret"},
+	}
+
+	row := &domain.VoucherImportRow{Request: domain.StoreVoucherRequest{Sku: "IDMR50"}, Fields: []string{"sku"}}
+	match, err := matchImportRow(existing, row)
+	assert.NoError(t, err)
+	assert.Equal(t, existing[2], match)
+
+	row = &domain.VoucherImportRow{Request: domain.StoreVoucherRequest{Sku: "ALFM25"}, Fields: []string{"sku"}}
+	_, err = matchImportRow(existing, row)
+	assert.Error(t, err)
+
+	row = &domain.VoucherImportRow{Request: domain.StoreVoucherRequest{Sku: "ALFM25", Vendor: "indomaret"}, Fields: []string{"sku", "vendor"}}
+	match, err = matchImportRow(existing, row)
+	assert.NoError(t, err)
+	assert.Equal(t, existing[1], match)
+
+	row = &domain.VoucherImportRow{Request: domain.StoreVoucherRequest{Sku: "XYZ"}, Fields: []string{"sku"}}
+	match, err = matchImportRow(existing, row)
+	assert.NoError(t, err)
+	assert.Nil(t, match)
+}
+
+func TestDiffRequests_AppliedFields(t *testing.T) {
+	current := domain.StoreVoucherRequest{Sku: "ALFM25", DistributorPrice: 24000, Stock: 5}
+	updated := current
+	applyRequestFields(&updated, &domain.StoreVoucherRequest{DistributorPrice: 24500, Stock: 5}, []string{"distributor_price", "stock"})
+
+	changes := diffRequests(current, updated)
+	assert.Equal(t, map[string]domain.VoucherFieldChange{
+		"distributor_price": {Old: 24000, New: 24500},
+	}, changes)
+}
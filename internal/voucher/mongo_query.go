@@ -0,0 +1,250 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// buildFilterQuery translates the filter conditions into a mongo query.
+// Archived vouchers are excluded unless the filter includes them.
+func buildFilterQuery(filter domain.VoucherFilter) bson.M {
+	query := bson.M{}
+	for _, condition := range filter.Conditions {
+		value := condition.Value
+		if condition.Operator.IsText() {
+			value = textPattern(condition.Operator, value.(string))
+		}
+
+		operators, ok := query[condition.Field].(bson.M)
+		if !ok {
+			operators = bson.M{}
+			query[condition.Field] = operators
+		}
+		operators[mongoOperator(condition.Operator)] = value
+	}
+
+	// A lone equality is written as a plain value
+	for field, operators := range query {
+		if eq, ok := operators.(bson.M)["$eq"]; ok && len(operators.(bson.M)) == 1 {
+			query[field] = eq
+		}
+	}
+
+	if !filter.IncludeDeleted {
+		query["deleted_at"] = nil
+	}
+
+	if filter.Search != "" {
+		search := textPattern(domain.FilterContains, filter.Search)
+		query["$or"] = bson.A{
+			bson.M{"sku_name": search},
+			bson.M{"sku": search},
+			bson.M{"brand_code": search},
+		}
+	}
+
+	return query
+}
+
+// buildSort translates the sort fields into a sort document. The _id field is
+// always appended as a tiebreaker so pages are stable.
+func buildSort(sortFields []domain.SortField) bson.D {
+	sortKeys := bson.D{}
+	for _, field := range sortFields {
+		order := 1
+		if field.Desc {
+			order = -1
+		}
+		sortKeys = append(sortKeys, bson.E{Key: field.Field, Value: order})
+	}
+
+	return append(sortKeys, bson.E{Key: "_id", Value: 1})
+}
+
+// buildProjection translates the requested fields into a projection. Sort
+// fields are always included so the next cursor can be built from the page.
+// It returns nil when every field is requested.
+func buildProjection(filter domain.VoucherFilter) bson.M {
+	if len(filter.Fields) == 0 {
+		return nil
+	}
+
+	projection := bson.M{"_id": 1}
+	for _, field := range filter.Fields {
+		if field != "id" {
+			projection[field] = 1
+		}
+	}
+	for _, field := range filter.Sort {
+		projection[field.Field] = 1
+	}
+
+	return projection
+}
+
+// mongoOperator returns the mongo query operator for a filter operator.
+func mongoOperator(operator domain.FilterOperator) string {
+	switch operator {
+	case domain.FilterNotIn:
+		return "$nin"
+	case domain.FilterContains, domain.FilterPrefix:
+		return "$regex"
+	}
+	return "$" + string(operator)
+}
+
+// textPattern builds a case-insensitive regex for a text operator. User input
+// is always escaped so it is matched literally.
+func textPattern(operator domain.FilterOperator, str string) primitive.Regex {
+	pattern := regexp.QuoteMeta(str)
+	if operator == domain.FilterPrefix {
+		pattern = "^" + pattern
+	}
+	return primitive.Regex{Pattern: pattern, Options: "i"}
+}
+
+// settleProductStatus mirrors domain.ProductStatus.ForStock as an update
+// stage, moving the product status along with the stored stock.
+var settleProductStatus = bson.D{{Key: "$set", Value: bson.M{
+	"product_status": bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{
+				"case": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$product_status", domain.ProductStatusAvailable}},
+					bson.M{"$lte": bson.A{"$stock", 0}},
+				}},
+				"then": domain.ProductStatusOutOfStock,
+			},
+			bson.M{
+				"case": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$product_status", domain.ProductStatusOutOfStock}},
+					bson.M{"$gt": bson.A{"$stock", 0}},
+				}},
+				"then": domain.ProductStatusAvailable,
+			},
+		},
+		"default": "$product_status",
+	}},
+}}}
+
+// stockUpdate changes the stock by delta and settles the product status.
+func stockUpdate(delta int) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"stock": bson.M{"$add": bson.A{"$stock", delta}}}}},
+		settleProductStatus,
+	}
+}
+
+// withStock returns the voucher as stockUpdate leaves it.
+func withStock(voucher domain.Voucher, delta int) *domain.Voucher {
+	voucher.Stock += delta
+	voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+	return &voucher
+}
+
+// statusUpdate sets the product status, settled against the stored stock.
+func statusUpdate(status domain.ProductStatus) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"product_status": status}}},
+		settleProductStatus,
+	}
+}
+
+// buildAggregatePipeline groups the vouchers matching the filter by the
+// groupBy field, one of domain.VoucherGroupFields, into domain.VoucherStats
+// ordered by the grouped value.
+func buildAggregatePipeline(filter domain.VoucherFilter, groupBy string) (mongo.Pipeline, error) {
+	if !domain.VoucherGroupFields[groupBy] {
+		return nil, &domain.InvalidFilterError{Params: []domain.InvalidFilterParam{
+			{Name: "group_by", Rule: "oneof", Param: "brand_code vendor product_status"},
+		}}
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: buildFilterQuery(filter)}},
+		{{Key: "$group", Value: bson.M{
+			"_id":                   "$" + groupBy,
+			"count":                 bson.M{"$sum": 1},
+			"total_stock":           bson.M{"$sum": "$stock"},
+			"min_nominal":           bson.M{"$min": "$nominal"},
+			"max_nominal":           bson.M{"$max": "$nominal"},
+			"avg_nominal":           bson.M{"$avg": "$nominal"},
+			"min_distributor_price": bson.M{"$min": "$distributor_price"},
+			"max_distributor_price": bson.M{"$max": "$distributor_price"},
+			"avg_distributor_price": bson.M{"$avg": "$distributor_price"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}, nil
+}
+
+// transitionFilter matches the vouchers allowed to move to status, see
+// domain.ProductStatus.CanTransitionTo.
+func transitionFilter(status domain.ProductStatus) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"product_status": bson.M{"$in": status.TransitionsFrom()}},
+		bson.M{"product_status": bson.M{"$nin": domain.ProductStatuses()}},
+	}}
+}
+
+// updateFilter matches the voucher an update applies to. Archived vouchers
+// are read-only until restored, and an update setting the product status
+// only matches vouchers allowed to move to it.
+func updateFilter(update *domain.VoucherUpdate) bson.M {
+	filter := bson.M{"_id": update.Id, "deleted_at": nil}
+	if setsField(update, "product_status") {
+		filter["$or"] = transitionFilter(update.Voucher.ProductStatus)["$or"]
+	}
+	if update.IfStock != nil {
+		filter["stock"] = *update.IfStock
+	}
+	return filter
+}
+
+// setsField reports whether an update sets the field with the given json
+// name.
+func setsField(update *domain.VoucherUpdate, field string) bool {
+	for _, name := range update.Fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldsUpdate sets the fields of an update and settles the product status.
+// Values are set as literals, so strings starting with $ are not read as
+// field paths.
+func fieldsUpdate(update *domain.VoucherUpdate) mongo.Pipeline {
+	voucher := reflect.ValueOf(update.Voucher).Elem()
+	set := bson.M{}
+	for _, field := range update.Fields {
+		index, ok := voucherJSONFields[field]
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(voucher.Type().Field(index).Tag.Get("bson"), ",")
+		set[name] = bson.M{"$literal": voucher.Field(index).Interface()}
+	}
+	return mongo.Pipeline{
+		{{Key: "$set", Value: set}},
+		settleProductStatus,
+	}
+}
+
+// withFields returns the voucher as fieldsUpdate leaves it.
+func withFields(voucher domain.Voucher, update *domain.VoucherUpdate) *domain.Voucher {
+	dst, src := reflect.ValueOf(&voucher).Elem(), reflect.ValueOf(update.Voucher).Elem()
+	for _, field := range update.Fields {
+		if index, ok := voucherJSONFields[field]; ok {
+			dst.Field(index).Set(src.Field(index))
+		}
+	}
+	voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+	return &voucher
+}
@@ -0,0 +1,132 @@
+package voucher
+
+import (
+	"go-multiple-query/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildFilterQuery(t *testing.T) {
+	filter := domain.VoucherFilter{
+		Conditions: []domain.FilterCondition{
+			{Field: "brand_code", Operator: domain.FilterIn, Value: []interface{}{"ALFM", "IDMR"}},
+			{Field: "nominal", Operator: domain.FilterEq, Value: 15000},
+			{Field: "nominal", Operator: domain.FilterGte, Value: 10000},
+			{Field: "stock", Operator: domain.FilterEq, Value: 76},
+			{Field: "sku", Operator: domain.FilterNotIn, Value: []interface{}{"ALFM25"}},
+			{Field: "sku_name", Operator: domain.FilterPrefix, Value: "Voucher (Alfa"},
+		},
+		Search: "alfa.mart",
+	}
+
+	search := primitive.Regex{Pattern: `alfa\.mart`, Options: "i"}
+	assert.Equal(t, bson.M{
+		"brand_code": bson.M{"$in": []interface{}{"ALFM", "IDMR"}},
+		"nominal":    bson.M{"$eq": 15000, "$gte": 10000},
+		"stock":      76,
+		"sku":        bson.M{"$nin": []interface{}{"ALFM25"}},
+		"sku_name":   bson.M{"$regex": primitive.Regex{Pattern: `^Voucher \(Alfa`, Options: "i"}},
+		"deleted_at": nil,
+		"$or": bson.A{
+			bson.M{"sku_name": search},
+			bson.M{"sku": search},
+			bson.M{"brand_code": search},
+		},
+	}, buildFilterQuery(filter))
+}
+
+func TestBuildFilterQuery_IncludeDeleted(t *testing.T) {
+	assert.Equal(t, bson.M{"deleted_at": nil}, buildFilterQuery(domain.VoucherFilter{}))
+	assert.Equal(t, bson.M{}, buildFilterQuery(domain.VoucherFilter{IncludeDeleted: true}))
+}
+
+func TestBuildSort(t *testing.T) {
+	sortKeys := buildSort([]domain.SortField{{Field: "nominal"}, {Field: "distributor_price", Desc: true}})
+	assert.Equal(t, bson.D{
+		{Key: "nominal", Value: 1},
+		{Key: "distributor_price", Value: -1},
+		{Key: "_id", Value: 1},
+	}, sortKeys)
+}
+
+func TestBuildProjection(t *testing.T) {
+	assert.Nil(t, buildProjection(domain.VoucherFilter{}))
+
+	filter := domain.VoucherFilter{
+		Fields: []string{"sku", "nominal"},
+		Sort:   []domain.SortField{{Field: "sku_name"}},
+	}
+	assert.Equal(t, bson.M{"_id": 1, "sku": 1, "nominal": 1, "sku_name": 1}, buildProjection(filter))
+}
+
+func TestBuildAggregatePipeline(t *testing.T) {
+	filter := domain.VoucherFilter{Conditions: []domain.FilterCondition{{Field: "vendor", Operator: domain.FilterEq, Value: "A"}}}
+
+	pipeline, err := buildAggregatePipeline(filter, "brand_code")
+	assert.NoError(t, err)
+	assert.Len(t, pipeline, 3)
+	assert.Equal(t, bson.D{{Key: "$match", Value: bson.M{"vendor": "A", "deleted_at": nil}}}, pipeline[0])
+
+	group := pipeline[1][0].Value.(bson.M)
+	assert.Equal(t, "$brand_code", group["_id"])
+	assert.Equal(t, bson.M{"$sum": "$stock"}, group["total_stock"])
+	assert.Equal(t, bson.M{"$avg": "$distributor_price"}, group["avg_distributor_price"])
+	assert.Equal(t, bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}}, pipeline[2])
+
+	_, err = buildAggregatePipeline(filter, "$where")
+	var filterErr *domain.InvalidFilterError
+	assert.ErrorAs(t, err, &filterErr)
+	assert.Equal(t, "group_by", filterErr.Params[0].Name)
+}
+
+func TestTransitionFilter(t *testing.T) {
+	filter := transitionFilter(domain.ProductStatusDraft)
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"product_status": bson.M{"$in": []domain.ProductStatus{domain.ProductStatusDraft}}},
+		bson.M{"product_status": bson.M{"$nin": []domain.ProductStatus{
+			domain.ProductStatusDraft, domain.ProductStatusAvailable, domain.ProductStatusOutOfStock, domain.ProductStatusDiscontinued,
+		}}},
+	}}, filter)
+}
+
+func TestFieldsUpdate(t *testing.T) {
+	id := primitive.NewObjectID()
+	update := &domain.VoucherUpdate{
+		Id:      id,
+		Voucher: &domain.Voucher{SkuName: "$5 voucher", DistributorPrice: 24000, Stock: 9},
+		Fields:  []string{"sku_name", "distributor_price"},
+	}
+
+	pipeline := fieldsUpdate(update)
+	assert.Equal(t, bson.D{{Key: "$set", Value: bson.M{
+		"sku_name":          bson.M{"$literal": "$5 voucher"},
+		"distributor_price": bson.M{"$literal": 24000},
+	}}}, pipeline[0])
+	assert.Equal(t, settleProductStatus, pipeline[1])
+	assert.Equal(t, bson.M{"_id": id, "deleted_at": nil}, updateFilter(update))
+
+	stock := 4
+	update.IfStock = &stock
+	assert.Equal(t, bson.M{"_id": id, "deleted_at": nil, "stock": 4}, updateFilter(update))
+
+	update.Voucher.ProductStatus = domain.ProductStatusDraft
+	update.Fields = append(update.Fields, "product_status")
+	assert.Equal(t, transitionFilter(domain.ProductStatusDraft)["$or"], updateFilter(update)["$or"])
+}
+
+func TestWithFields(t *testing.T) {
+	stored := domain.Voucher{Sku: "ALFM25", DistributorPrice: 24000, Stock: 3, ProductStatus: domain.ProductStatusAvailable}
+	update := &domain.VoucherUpdate{
+		Voucher: &domain.Voucher{DistributorPrice: 24500, Stock: 0, Sku: "ignored"},
+		Fields:  []string{"distributor_price", "stock"},
+	}
+
+	after := withFields(stored, update)
+	assert.Equal(t, "ALFM25", after.Sku)
+	assert.Equal(t, 24500, after.DistributorPrice)
+	assert.Equal(t, 0, after.Stock)
+	assert.Equal(t, domain.ProductStatusOutOfStock, after.ProductStatus)
+}
@@ -2,10 +2,12 @@ package voucher
 
 import (
 	"context"
+	"errors"
 	"go-multiple-query/internal/domain"
-	"reflect"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,15 +15,18 @@ import (
 )
 
 type mongodbRepository struct {
-	db *mongo.Database
+	db                 *mongo.Database
+	cursorSecret       []byte
+	uniqueSkuPerVendor bool
+	logger             *zerolog.Logger
 }
 
 // FindByID implements domain.VoucherRepository.
-func (m *mongodbRepository) FindByID(id primitive.ObjectID) (*domain.Voucher, error) {
+func (m *mongodbRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error) {
 	coll := m.db.Collection("vouchers")
 
 	var voucher domain.Voucher
-	err := coll.FindOne(context.TODO(), primitive.M{"_id": id}).Decode(&voucher)
+	err := coll.FindOne(ctx, primitive.M{"_id": id}).Decode(&voucher)
 	if err != nil {
 		return nil, err
 	}
@@ -29,90 +34,143 @@ func (m *mongodbRepository) FindByID(id primitive.ObjectID) (*domain.Voucher, er
 	return &voucher, nil
 }
 
-// Count implements domain.VoucherRepository.
-func (m *mongodbRepository) Count(filter domain.VoucherFilter) (int64, error) {
-	var count int64
-
+// FindBySkus implements domain.VoucherRepository. Archived vouchers are
+// excluded.
+func (m *mongodbRepository) FindBySkus(ctx context.Context, skus []string) ([]*domain.Voucher, error) {
 	coll := m.db.Collection("vouchers")
 
-	// loop over filter and build query
-	query := bson.M{}
-	v := reflect.ValueOf(filter)
-	typeOfFilter := v.Type()
+	cursor, err := coll.Find(ctx, bson.M{"sku": bson.M{"$in": skus}, "deleted_at": nil})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	for i := 0; i < v.NumField(); i++ {
-		fieldName := typeOfFilter.Field(i).Name
+	vouchers := []*domain.Voucher{}
+	if err := cursor.All(ctx, &vouchers); err != nil {
+		return nil, err
+	}
 
-		// Skip pagination and sorting
-		if fieldName == "Page" || fieldName == "Size" || fieldName == "OrderBy" || fieldName == "SortOrder" {
-			continue
-		}
+	return vouchers, nil
+}
 
-		fieldValue := v.Field(i).Interface()
-		if str, ok := fieldValue.(string); ok && str != "" {
-			query[typeOfFilter.Field(i).Tag.Get("query")] = fieldValue
+// FindWithFilter implements domain.VoucherRepository.
+func (m *mongodbRepository) FindWithFilter(ctx context.Context, filter domain.VoucherFilter) (*domain.VoucherPage, error) {
+	page, size := filter.Page, filter.Size
+	offset := (page - 1) * size
+
+	query := buildFilterQuery(filter)
+	sortKeys := buildSort(filter.Sort)
+	projection := buildProjection(filter)
+
+	// A cursor replaces the page number and continues after its last voucher
+	var keyset bson.M
+	if filter.Cursor != "" {
+		values, err := decodeCursor(m.cursorSecret, filter.Cursor, sortKeys)
+		if err != nil {
+			return nil, &domain.InvalidFilterError{Params: []domain.InvalidFilterParam{
+				{Name: "cursor", Rule: "cursor"},
+			}}
 		}
+		keyset = keysetCondition(sortKeys, values)
+		offset = 0
 	}
 
-	count, err := coll.CountDocuments(context.TODO(), query)
+	// Fetch one extra voucher to know whether there is a next page
+	var vouchers []*domain.Voucher
+	var total *int64
+	var err error
+	if filter.WithTotal {
+		vouchers, total, err = m.findWithTotal(ctx, query, keyset, sortKeys, projection, offset, size+1)
+	} else {
+		vouchers, err = m.find(ctx, query, keyset, sortKeys, projection, offset, size+1)
+	}
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if len(vouchers) == 0 {
+		return nil, mongo.ErrNoDocuments
 	}
 
-	return count, nil
+	result := &domain.VoucherPage{Vouchers: vouchers, Total: total}
+	if len(vouchers) > size {
+		result.Vouchers = vouchers[:size]
+
+		result.NextCursor, err = encodeCursor(m.cursorSecret, sortKeys, vouchers[size-1])
+		if err != nil {
+			return nil, err
+		}
+		if filter.Cursor == "" {
+			result.NextPage = page + 1
+		}
+	}
+
+	return result, nil
 }
 
-// FindWithFilter implements domain.VoucherRepository.
-func (m *mongodbRepository) FindWithFilter(filter domain.VoucherFilter) ([]*domain.Voucher, int, error) {
+// Export implements domain.VoucherRepository. Vouchers are decoded one at a
+// time from the cursor and passed to each, so memory use does not grow with
+// the number of matches. Pagination is ignored, every match is exported.
+// Sorts no index covers may spill to disk, as sorting every match can exceed
+// the memory limit of an in-memory sort.
+func (m *mongodbRepository) Export(ctx context.Context, filter domain.VoucherFilter, each func(*domain.Voucher) error) error {
 	coll := m.db.Collection("vouchers")
-	var vouchers []*domain.Voucher
-
-	page, _ := strconv.Atoi(filter.Page)
-	size, _ := strconv.Atoi(filter.Size)
-	offset := (page - 1) * size
 
-	// loop over filter and build query
-	query := bson.M{}
-	v := reflect.ValueOf(filter)
-	typeOfFilter := v.Type()
+	findOptions := options.Find().
+		SetSort(buildSort(filter.Sort)).
+		SetBatchSize(exportBatchSize).
+		SetAllowDiskUse(true)
+	if projection := buildProjection(filter); projection != nil {
+		findOptions.SetProjection(projection)
+	}
 
-	for i := 0; i < v.NumField(); i++ {
-		fieldName := typeOfFilter.Field(i).Name
+	cursor, err := coll.Find(ctx, buildFilterQuery(filter), findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
 
-		// Skip pagination and sorting
-		if fieldName == "Page" || fieldName == "Size" || fieldName == "OrderBy" || fieldName == "SortOrder" {
-			continue
+	for cursor.Next(ctx) {
+		var voucher domain.Voucher
+		if err := cursor.Decode(&voucher); err != nil {
+			return err
 		}
-
-		fieldValue := v.Field(i).Interface()
-		if str, ok := fieldValue.(string); ok && str != "" {
-			query[typeOfFilter.Field(i).Tag.Get("query")] = fieldValue
+		if err := each(&voucher); err != nil {
+			return err
 		}
 	}
 
-	var sortOrder int
-	if filter.SortOrder == "asc" {
-		sortOrder = 1
-	} else {
-		sortOrder = -1
+	return cursor.Err()
+}
+
+// find fetches a single page of vouchers matching the query.
+func (m *mongodbRepository) find(ctx context.Context, query, keyset bson.M, sortKeys bson.D, projection bson.M, offset, limit int) ([]*domain.Voucher, error) {
+	coll := m.db.Collection("vouchers")
+	var vouchers []*domain.Voucher
+
+	if keyset != nil {
+		query = bson.M{"$and": bson.A{query, keyset}}
 	}
 
 	findOptions := options.Find().
-		SetLimit(int64(size)).
+		SetLimit(int64(limit)).
 		SetSkip(int64(offset)).
-		SetSort(bson.D{{Key: filter.OrderBy, Value: sortOrder}})
+		SetSort(sortKeys)
+	if projection != nil {
+		findOptions.SetProjection(projection)
+	}
 
-	cursor, err := coll.Find(context.TODO(), query, findOptions)
+	cursor, err := coll.Find(ctx, query, findOptions)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		var voucher domain.Voucher
 		err := cursor.Decode(&voucher)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 
 		vouchers = append(vouchers, &voucher)
@@ -120,39 +178,765 @@ func (m *mongodbRepository) FindWithFilter(filter domain.VoucherFilter) ([]*doma
 
 	// Check for any errors during cursor iteration
 	if err := cursor.Err(); err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	if len(vouchers) == 0 {
-		return nil, 0, mongo.ErrNoDocuments
+	return vouchers, nil
+}
+
+// findWithTotal fetches a single page of vouchers together with the total
+// number of vouchers matching the query in one $facet aggregation. The keyset
+// only narrows the page so the total stays the same across cursors.
+func (m *mongodbRepository) findWithTotal(ctx context.Context, query, keyset bson.M, sortKeys bson.D, projection bson.M, offset, limit int) ([]*domain.Voucher, *int64, error) {
+	coll := m.db.Collection("vouchers")
+
+	items := bson.A{}
+	if keyset != nil {
+		items = append(items, bson.M{"$match": keyset})
+	}
+	items = append(items,
+		bson.M{"$sort": sortKeys},
+		bson.M{"$skip": offset},
+		bson.M{"$limit": limit},
+	)
+	if projection != nil {
+		items = append(items, bson.M{"$project": projection})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: query}},
+		{{Key: "$facet", Value: bson.M{
+			"items": items,
+			"total": bson.A{bson.M{"$count": "count"}},
+		}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Items []*domain.Voucher `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, nil, err
 	}
 
-	var nextCursor int
-	if len(vouchers) > 0 {
-		nextCursor = page + 1
+	var total int64
+	if len(results) == 0 {
+		return nil, &total, nil
+	}
+	if len(results[0].Total) > 0 {
+		total = results[0].Total[0].Count
+	}
+
+	return results[0].Items, &total, nil
+}
+
+// Aggregate implements domain.VoucherRepository.
+func (m *mongodbRepository) Aggregate(ctx context.Context, filter domain.VoucherFilter, groupBy string) ([]*domain.VoucherStats, error) {
+	coll := m.db.Collection("vouchers")
+
+	pipeline, err := buildAggregatePipeline(filter, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	stats := []*domain.VoucherStats{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
 	}
 
-	return vouchers, nextCursor, nil
+	return stats, nil
 }
 
 // Store implements domain.VoucherRepository.
-func (m *mongodbRepository) Store(voucher *domain.Voucher) (*domain.Voucher, error) {
+func (m *mongodbRepository) Store(ctx context.Context, actor domain.Actor, voucher *domain.Voucher) (*domain.Voucher, error) {
 	coll := m.db.Collection("vouchers")
 
-	result, err := coll.InsertOne(context.TODO(), voucher)
+	result, err := coll.InsertOne(ctx, voucher)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &domain.Voucher{}, m.duplicateSkuError(ctx, voucher)
+		}
 		return &domain.Voucher{}, err
 	}
 
 	// get by id
-	voucher, err = m.FindByID(result.InsertedID.(primitive.ObjectID))
+	voucher, err = m.FindByID(ctx, result.InsertedID.(primitive.ObjectID))
 	if err != nil {
 		return &domain.Voucher{}, err
 	}
 
+	m.recordHistory(ctx, historyEntries(actor, nil, voucher, time.Now()))
+
 	return voucher, nil
 }
 
-func NewMongoRepository(db *mongo.Database) domain.VoucherRepository {
-	return &mongodbRepository{db}
+// StoreMany implements domain.VoucherRepository. It returns one error per
+// voucher, nil for the vouchers that were inserted.
+func (m *mongodbRepository) StoreMany(ctx context.Context, actor domain.Actor, vouchers []*domain.Voucher, ordered bool) ([]error, error) {
+	coll := m.db.Collection("vouchers")
+
+	docs := make([]interface{}, 0, len(vouchers))
+	for _, voucher := range vouchers {
+		voucher.Id = primitive.NewObjectID()
+		docs = append(docs, voucher)
+	}
+
+	errs := make([]error, len(vouchers))
+	_, err := coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(ordered))
+	if err == nil {
+		m.recordCreated(ctx, actor, vouchers, errs)
+		return errs, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, err
+	}
+
+	for _, writeErr := range bulkErr.WriteErrors {
+		if mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			errs[writeErr.Index] = m.duplicateSkuError(ctx, vouchers[writeErr.Index])
+		} else {
+			errs[writeErr.Index] = writeErr.WriteError
+		}
+
+		// An ordered insert stops at the first failure
+		if ordered {
+			for i := writeErr.Index + 1; i < len(errs); i++ {
+				errs[i] = domain.ErrBulkSkipped
+			}
+		}
+	}
+
+	m.recordCreated(ctx, actor, vouchers, errs)
+	return errs, nil
+}
+
+// duplicateSkuError returns the error for a write of voucher rejected by
+// the unique SKU index, telling whether the SKU is held by an archived
+// voucher.
+func (m *mongodbRepository) duplicateSkuError(ctx context.Context, voucher *domain.Voucher) *domain.DuplicateSkuError {
+	query := bson.M{"_id": bson.M{"$ne": voucher.Id}, "sku": voucher.Sku}
+	if m.uniqueSkuPerVendor {
+		query["vendor"] = voucher.Vendor
+	}
+
+	var holder domain.Voucher
+	err := m.db.Collection("vouchers").FindOne(ctx, query).Decode(&holder)
+	return &domain.DuplicateSkuError{Sku: voucher.Sku, Archived: err == nil && holder.DeletedAt != nil}
+}
+
+// recordCreated records the history of the vouchers inserted by StoreMany.
+func (m *mongodbRepository) recordCreated(ctx context.Context, actor domain.Actor, vouchers []*domain.Voucher, errs []error) {
+	now := time.Now()
+
+	var entries []*domain.VoucherHistory
+	for i, voucher := range vouchers {
+		if errs[i] == nil {
+			entries = append(entries, historyEntries(actor, nil, voucher, now)...)
+		}
+	}
+	m.recordHistory(ctx, entries)
+}
+
+// Update implements domain.VoucherRepository. Only the fields of the update
+// are written, in a single update settling the product status against the
+// stored stock, so concurrent stock changes are kept.
+func (m *mongodbRepository) Update(ctx context.Context, actor domain.Actor, update *domain.VoucherUpdate) (*domain.Voucher, error) {
+	coll := m.db.Collection("vouchers")
+
+	var before domain.Voucher
+	err := coll.FindOneAndUpdate(ctx, updateFilter(update), fieldsUpdate(update)).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return &domain.Voucher{}, m.updateError(ctx, update)
+	}
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &domain.Voucher{}, m.duplicateSkuError(ctx, m.updated(ctx, update))
+		}
+		return &domain.Voucher{}, err
+	}
+
+	after := withFields(before, update)
+	m.recordHistory(ctx, historyEntries(actor, &before, after, time.Now()))
+
+	return after, nil
+}
+
+// updateError explains why an update matched no voucher.
+func (m *mongodbRepository) updateError(ctx context.Context, update *domain.VoucherUpdate) error {
+	stored, err := m.FindByID(ctx, update.Id)
+	if err != nil {
+		return err
+	}
+	if stored.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+	if err := storedUpdateError(stored, update); err != nil {
+		return err
+	}
+	// The voucher changed back since the update
+	return domain.ErrVoucherChanged
+}
+
+// storedUpdateError returns the error of an update the stored voucher does
+// not allow, nil when it does.
+func storedUpdateError(stored *domain.Voucher, update *domain.VoucherUpdate) error {
+	if update.IfStock != nil && stored.Stock != *update.IfStock {
+		return domain.ErrVoucherChanged
+	}
+	if setsField(update, "product_status") && !stored.ProductStatus.CanTransitionTo(update.Voucher.ProductStatus) {
+		return &domain.InvalidStatusTransitionError{From: stored.ProductStatus, To: update.Voucher.ProductStatus}
+	}
+	return nil
+}
+
+// updated returns the voucher as update leaves it, for describing a
+// conflicting SKU.
+func (m *mongodbRepository) updated(ctx context.Context, update *domain.VoucherUpdate) *domain.Voucher {
+	stored, err := m.FindByID(ctx, update.Id)
+	if err != nil {
+		voucher := *update.Voucher
+		voucher.Id = update.Id
+		return &voucher
+	}
+	return withFields(*stored, update)
+}
+
+// UpdateMany implements domain.VoucherRepository. The updates are written in
+// a single unordered bulk write, each only setting its fields like Update. It
+// returns one error per update, nil for the updates that were written.
+func (m *mongodbRepository) UpdateMany(ctx context.Context, actor domain.Actor, updates []*domain.VoucherUpdate) ([]error, error) {
+	coll := m.db.Collection("vouchers")
+
+	models := make([]mongo.WriteModel, 0, len(updates))
+	for _, update := range updates {
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(updateFilter(update)).SetUpdate(fieldsUpdate(update)))
+	}
+
+	errs := make([]error, len(updates))
+	result, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return nil, err
+		}
+
+		for _, writeErr := range bulkErr.WriteErrors {
+			update := updates[writeErr.Index]
+			if mongo.IsDuplicateKeyError(writeErr.WriteError) {
+				errs[writeErr.Index] = m.duplicateSkuError(ctx, withFields(*update.Before, update))
+			} else {
+				errs[writeErr.Index] = writeErr.WriteError
+			}
+		}
+	}
+
+	written := 0
+	for _, err := range errs {
+		if err == nil {
+			written++
+		}
+	}
+	if result.MatchedCount < int64(written) {
+		if err := m.unmatchedUpdateErrors(ctx, updates, errs); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	var entries []*domain.VoucherHistory
+	for i, update := range updates {
+		if errs[i] == nil {
+			entries = append(entries, historyEntries(actor, update.Before, withFields(*update.Before, update), now)...)
+		}
+	}
+	m.recordHistory(ctx, entries)
+
+	return errs, nil
+}
+
+// unmatchedUpdateErrors sets the errors of the updates of UpdateMany that
+// matched no voucher, reading the stored vouchers once for all of them.
+func (m *mongodbRepository) unmatchedUpdateErrors(ctx context.Context, updates []*domain.VoucherUpdate, errs []error) error {
+	var ids []primitive.ObjectID
+	for i, update := range updates {
+		if errs[i] == nil {
+			ids = append(ids, update.Id)
+		}
+	}
+
+	cursor, err := m.db.Collection("vouchers").Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": nil})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var vouchers []*domain.Voucher
+	if err := cursor.All(ctx, &vouchers); err != nil {
+		return err
+	}
+	stored := make(map[primitive.ObjectID]*domain.Voucher, len(vouchers))
+	for _, voucher := range vouchers {
+		stored[voucher.Id] = voucher
+	}
+
+	for i, update := range updates {
+		if errs[i] != nil {
+			continue
+		}
+		if voucher, ok := stored[update.Id]; ok {
+			errs[i] = storedUpdateError(voucher, update)
+		} else {
+			errs[i] = mongo.ErrNoDocuments
+		}
+	}
+	return nil
+}
+
+// Delete implements domain.VoucherRepository. Vouchers are archived by
+// setting deleted_at rather than removed.
+func (m *mongodbRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	coll := m.db.Collection("vouchers")
+
+	result, err := coll.UpdateOne(ctx,
+		bson.M{"_id": id, "deleted_at": nil},
+		bson.M{"$set": bson.M{"deleted_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// Restore implements domain.VoucherRepository. Only archived vouchers are
+// restored.
+func (m *mongodbRepository) Restore(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error) {
+	coll := m.db.Collection("vouchers")
+
+	var voucher domain.Voucher
+	err := coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deleted_at": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&voucher)
+	if err == mongo.ErrNoDocuments {
+		if _, err := m.FindByID(ctx, id); err != nil {
+			return &domain.Voucher{}, err
+		}
+		return &domain.Voucher{}, domain.ErrVoucherNotArchived
+	}
+	if err != nil {
+		return &domain.Voucher{}, err
+	}
+
+	return &voucher, nil
+}
+
+// Reserve implements domain.VoucherRepository. The stock check and decrement
+// run as a single update, so concurrent reservations never take the stock
+// below zero. Vouchers reserved down to zero stock go out of stock.
+func (m *mongodbRepository) Reserve(ctx context.Context, actor domain.Actor, id primitive.ObjectID, quantity int, expiresAt time.Time) (*domain.Reservation, error) {
+	vouchers := m.db.Collection("vouchers")
+
+	var before domain.Voucher
+	err := vouchers.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": nil, "stock": bson.M{"$gte": quantity}},
+		stockUpdate(-quantity),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		var voucher domain.Voucher
+		err := vouchers.FindOne(ctx, bson.M{"_id": id, "deleted_at": nil}).Decode(&voucher)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &domain.InsufficientStockError{Requested: quantity, Available: voucher.Stock}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &domain.Reservation{
+		Id:        primitive.NewObjectID(),
+		VoucherId: id,
+		Quantity:  quantity,
+		Status:    domain.ReservationActive,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if _, err := m.db.Collection("reservations").InsertOne(ctx, reservation); err != nil {
+		// Give the stock back as nothing holds it
+		_, _ = vouchers.UpdateOne(ctx, bson.M{"_id": id}, stockUpdate(quantity))
+		return nil, err
+	}
+
+	after := withStock(before, -quantity)
+	m.recordHistory(ctx, historyEntries(actor, &before, after, reservation.CreatedAt))
+
+	return reservation, nil
+}
+
+// Release implements domain.VoucherRepository. A zero quantity releases the
+// whole reservation. The reservation is updated before the stock is returned,
+// so concurrent releases of the same reservation only return it once, and
+// both happen in one transaction so the stock cannot be lost in between.
+func (m *mongodbRepository) Release(ctx context.Context, actor domain.Actor, id, reservationId primitive.ObjectID, quantity int) (*domain.Reservation, error) {
+	reservation, err := m.inTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return m.release(ctx, actor, id, reservationId, quantity)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation.(*domain.Reservation), nil
+}
+
+func (m *mongodbRepository) release(ctx context.Context, actor domain.Actor, id, reservationId primitive.ObjectID, quantity int) (*domain.Reservation, error) {
+	reservations := m.db.Collection("reservations")
+	now := time.Now()
+
+	active := func(quantity interface{}) bson.M {
+		filter := bson.M{
+			"_id":        reservationId,
+			"voucher_id": id,
+			"status":     domain.ReservationActive,
+			"expires_at": bson.M{"$gt": now},
+		}
+		if quantity != nil {
+			filter["quantity"] = quantity
+		}
+		return filter
+	}
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var reservation domain.Reservation
+	err := mongo.ErrNoDocuments
+	released := quantity
+	if quantity > 0 {
+		err = reservations.FindOneAndUpdate(ctx,
+			active(bson.M{"$gt": quantity}),
+			bson.M{"$inc": bson.M{"quantity": -quantity}},
+			after,
+		).Decode(&reservation)
+	}
+	if err == mongo.ErrNoDocuments {
+		var remaining interface{}
+		if quantity > 0 {
+			remaining = quantity
+		}
+		err = reservations.FindOneAndUpdate(ctx,
+			active(remaining),
+			bson.M{"$set": bson.M{"status": domain.ReservationReleased, "released_at": now}},
+			after,
+		).Decode(&reservation)
+		released = reservation.Quantity
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, m.releaseError(ctx, id, reservationId, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.returnStock(ctx, actor, id, released, now); err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+// releaseError explains why a reservation could not be released.
+func (m *mongodbRepository) releaseError(ctx context.Context, id, reservationId primitive.ObjectID, now time.Time) error {
+	var reservation domain.Reservation
+	err := m.db.Collection("reservations").
+		FindOne(ctx, bson.M{"_id": reservationId, "voucher_id": id}).
+		Decode(&reservation)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return domain.ErrReservationNotFound
+	case err != nil:
+		return err
+	case reservation.Status != domain.ReservationActive || !reservation.ExpiresAt.After(now):
+		return domain.ErrReservationClosed
+	}
+	return domain.ErrReleaseExceedsReservation
+}
+
+// ReleaseExpired implements domain.VoucherRepository. Reservations are
+// claimed one at a time, so several instances can sweep at once without
+// returning the same stock twice. Each claim and its stock return happen in
+// one transaction, a failed return leaves the reservation to the next sweep.
+func (m *mongodbRepository) ReleaseExpired(ctx context.Context, actor domain.Actor, now time.Time) (int, error) {
+	released := 0
+	for {
+		expired, err := m.inTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+			return m.expireReservation(ctx, actor, now)
+		})
+		if err != nil {
+			return released, err
+		}
+		if !expired.(bool) {
+			return released, nil
+		}
+		released++
+	}
+}
+
+// expireReservation marks a single expired reservation as expired and
+// returns its stock. It reports false when no reservation is left to expire.
+func (m *mongodbRepository) expireReservation(ctx context.Context, actor domain.Actor, now time.Time) (bool, error) {
+	var reservation domain.Reservation
+	err := m.db.Collection("reservations").FindOneAndUpdate(ctx,
+		bson.M{"status": domain.ReservationActive, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": domain.ReservationExpired, "released_at": now}},
+	).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := m.returnStock(ctx, actor, reservation.VoucherId, reservation.Quantity, now); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// inTransaction runs fn in a transaction, retried on transient errors.
+func (m *mongodbRepository) inTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) (interface{}, error)) (interface{}, error) {
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	return session.WithTransaction(ctx, fn)
+}
+
+// returnStock adds released stock back to a voucher.
+func (m *mongodbRepository) returnStock(ctx context.Context, actor domain.Actor, id primitive.ObjectID, quantity int, now time.Time) error {
+	var before domain.Voucher
+	err := m.db.Collection("vouchers").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		stockUpdate(quantity),
+	).Decode(&before)
+	if err != nil {
+		return err
+	}
+
+	m.recordHistory(ctx, historyEntries(actor, &before, withStock(before, quantity), now))
+	return nil
+}
+
+// UpdateStatus implements domain.VoucherRepository. The transition is
+// checked in the update filter, so it holds against concurrent changes.
+func (m *mongodbRepository) UpdateStatus(ctx context.Context, actor domain.Actor, id primitive.ObjectID, status domain.ProductStatus) (*domain.Voucher, error) {
+	coll := m.db.Collection("vouchers")
+
+	filter := transitionFilter(status)
+	filter["_id"], filter["deleted_at"] = id, nil
+
+	var voucher domain.Voucher
+	err := coll.FindOneAndUpdate(ctx, filter, statusUpdate(status)).Decode(&voucher)
+	if err != mongo.ErrNoDocuments {
+		if err != nil {
+			return nil, err
+		}
+
+		after := voucher
+		after.ProductStatus = status.ForStock(voucher.Stock)
+		m.recordHistory(ctx, historyEntries(actor, &voucher, &after, time.Now()))
+		return &after, nil
+	}
+
+	err = coll.FindOne(ctx, bson.M{"_id": id, "deleted_at": nil}).Decode(&voucher)
+	if err != nil {
+		return nil, err
+	}
+	return nil, &domain.InvalidStatusTransitionError{From: voucher.ProductStatus, To: status}
+}
+
+// FindHistory implements domain.VoucherRepository.
+func (m *mongodbRepository) FindHistory(ctx context.Context, id primitive.ObjectID, page, size int) (*domain.VoucherHistoryPage, error) {
+	coll := m.db.Collection("voucher_history")
+	query := bson.M{"voucher_id": id}
+
+	total, err := coll.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "changed_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * size)).
+		SetLimit(int64(size))
+
+	cursor, err := coll.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	history := []*domain.VoucherHistory{}
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+
+	return &domain.VoucherHistoryPage{History: history, Total: total}, nil
+}
+
+// recordHistory appends entries to the voucher history. History is never
+// updated or deleted. It runs once the voucher change is stored, so a failure
+// is logged rather than failing a change that already happened. Inside a
+// transaction the failed write still aborts it, so nothing is left half done.
+func (m *mongodbRepository) recordHistory(ctx context.Context, entries []*domain.VoucherHistory) {
+	if len(entries) == 0 {
+		return
+	}
+
+	docs := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		docs = append(docs, entry)
+	}
+
+	if _, err := m.db.Collection("voucher_history").InsertMany(ctx, docs); err != nil {
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.VoucherId.Hex())
+		}
+		m.logger.Error().Err(err).Strs("voucher_ids", ids).Msg("Voucher history could not be recorded")
+	}
+}
+
+// EnsureIndexes creates the indexes the repository relies on. SKUs are unique
+// across the collection, or per vendor when uniqueSkuPerVendor is set.
+// Archived vouchers keep their SKU reserved so they can be restored. Stock
+// reservations are indexed for the expiry sweep and history per voucher.
+func EnsureIndexes(ctx context.Context, db *mongo.Database, uniqueSkuPerVendor bool) error {
+	_, err := db.Collection("reservations").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("status_expires_at"),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("voucher_history").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "voucher_id", Value: 1}, {Key: "changed_at", Value: -1}},
+		Options: options.Index().SetName("voucher_id_changed_at"),
+	})
+	if err != nil {
+		return err
+	}
+
+	coll := db.Collection("vouchers")
+
+	// The unique index cannot be built over vouchers that already share a
+	// SKU, those are reported instead
+	duplicates, err := findDuplicateSkus(ctx, coll, uniqueSkuPerVendor)
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return &UniqueSkuIndexError{Skus: duplicates}
+	}
+
+	keys := bson.D{{Key: "sku", Value: 1}}
+	name := "sku_unique"
+	if uniqueSkuPerVendor {
+		keys = bson.D{{Key: "vendor", Value: 1}, {Key: "sku", Value: 1}}
+		name = "vendor_sku_unique"
+	}
+
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetName(name).SetUnique(true),
+	})
+	return err
+}
+
+// maxReportedDuplicateSkus caps the SKUs listed in a UniqueSkuIndexError.
+const maxReportedDuplicateSkus = 100
+
+// UniqueSkuIndexError is returned by EnsureIndexes when stored vouchers
+// already share a SKU, so the unique SKU index cannot be created. Skus lists
+// the shared SKUs, prefixed by their vendor when SKUs are unique per vendor.
+type UniqueSkuIndexError struct {
+	Skus []string
+}
+
+func (e *UniqueSkuIndexError) Error() string {
+	return "unique sku index not created, vouchers share the skus " + strings.Join(e.Skus, ", ")
+}
+
+// findDuplicateSkus returns the SKUs held by more than one voucher, archived
+// vouchers included.
+func findDuplicateSkus(ctx context.Context, coll *mongo.Collection, uniqueSkuPerVendor bool) ([]string, error) {
+	key := bson.M{"sku": "$sku"}
+	if uniqueSkuPerVendor {
+		key["vendor"] = "$vendor"
+	}
+
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": key, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.vendor", Value: 1}, {Key: "_id.sku", Value: 1}}}},
+		{{Key: "$limit", Value: maxReportedDuplicateSkus}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Id struct {
+			Sku    string `bson:"sku"`
+			Vendor string `bson:"vendor"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	skus := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if uniqueSkuPerVendor {
+			skus = append(skus, group.Id.Vendor+"/"+group.Id.Sku)
+			continue
+		}
+		skus = append(skus, group.Id.Sku)
+	}
+	return skus, nil
+}
+
+// NewMongoRepository creates a new instance of VoucherRepository. The cursor
+// secret signs the keyset pagination cursors handed out to clients,
+// uniqueSkuPerVendor must match the unique index built by EnsureIndexes.
+// History that cannot be recorded is reported to logger.
+func NewMongoRepository(db *mongo.Database, cursorSecret []byte, uniqueSkuPerVendor bool, logger *zerolog.Logger) domain.VoucherRepository {
+	return &mongodbRepository{
+		db:                 db,
+		cursorSecret:       cursorSecret,
+		uniqueSkuPerVendor: uniqueSkuPerVendor,
+		logger:             logger,
+	}
 }
@@ -0,0 +1,287 @@
+package voucher
+
+import (
+	"context"
+	"go-multiple-query/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var logger = zerolog.Nop()
+
+// value returns the reply of a findAndModify command matching doc, or no
+// document when doc is nil.
+func value(doc interface{}) bson.D {
+	return mtest.CreateSuccessResponse(bson.E{Key: "value", Value: doc})
+}
+
+func TestStore_HistoryFailure(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+
+	mt.Run("keeps the stored voucher", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "sku", Value: "ALFM25"}}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "history unavailable"}),
+		)
+
+		voucher, err := repo.Store(context.Background(), domain.Actor{}, &domain.Voucher{Sku: "ALFM25"})
+		assert.NoError(t, err)
+		assert.Equal(t, id, voucher.Id)
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+	stored := bson.D{{Key: "_id", Value: id}, {Key: "sku", Value: "ALFM25"}, {Key: "stock", Value: 3}, {Key: "product_status", Value: domain.ProductStatusAvailable}}
+
+	mt.Run("writes only the given fields", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(stored), mtest.CreateSuccessResponse())
+
+		voucher, err := repo.Update(context.Background(), domain.Actor{}, &domain.VoucherUpdate{
+			Id:      id,
+			Voucher: &domain.Voucher{DistributorPrice: 24500},
+			Fields:  []string{"distributor_price"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, voucher.Stock)
+		assert.Equal(t, 24500, voucher.DistributorPrice)
+		assert.Equal(t, "findAndModify", mt.GetStartedEvent().CommandName)
+	})
+
+	mt.Run("rejects a stock changed since it was read", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(nil), mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch, stored))
+
+		stock := 5
+		_, err := repo.Update(context.Background(), domain.Actor{}, &domain.VoucherUpdate{
+			Id:      id,
+			Voucher: &domain.Voucher{Stock: 5},
+			Fields:  []string{"stock"},
+			IfStock: &stock,
+		})
+		assert.ErrorIs(t, err, domain.ErrVoucherChanged)
+	})
+
+	mt.Run("rejects an invalid status transition", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(nil), mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch, stored))
+
+		_, err := repo.Update(context.Background(), domain.Actor{}, &domain.VoucherUpdate{
+			Id:      id,
+			Voucher: &domain.Voucher{ProductStatus: domain.ProductStatusDraft},
+			Fields:  []string{"product_status"},
+		})
+		assert.Equal(t, &domain.InvalidStatusTransitionError{From: domain.ProductStatusAvailable, To: domain.ProductStatusDraft}, err)
+	})
+}
+
+func TestUpdateMany(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	first, second := &domain.Voucher{Id: primitive.NewObjectID(), Sku: "ALFM25", Stock: 3}, &domain.Voucher{Id: primitive.NewObjectID(), Sku: "ALFM50", Stock: 1}
+	updates := func() []*domain.VoucherUpdate {
+		return []*domain.VoucherUpdate{
+			{Id: first.Id, Voucher: &domain.Voucher{Stock: 5}, Fields: []string{"stock"}, Before: first},
+			{Id: second.Id, Voucher: &domain.Voucher{Sku: "ALFM25"}, Fields: []string{"sku"}, Before: second},
+		}
+	}
+
+	mt.Run("writes every update in one bulk write", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}, bson.E{Key: "nModified", Value: 2}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		errs, err := repo.UpdateMany(context.Background(), domain.Actor{}, updates())
+		assert.NoError(t, err)
+		assert.Equal(t, []error{nil, nil}, errs)
+
+		events := mt.GetAllStartedEvents()
+		assert.Len(t, events, 2)
+		assert.Equal(t, "update", events[0].CommandName)
+		assert.Equal(t, "insert", events[1].CommandName)
+	})
+
+	mt.Run("reports the updates that failed", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "writeErrors", Value: bson.A{
+				bson.D{{Key: "index", Value: 1}, {Key: "code", Value: 11000}, {Key: "errmsg", Value: "duplicate key"}},
+			}}),
+			mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch),
+		)
+
+		errs, err := repo.UpdateMany(context.Background(), domain.Actor{}, updates())
+		assert.NoError(t, err)
+		assert.ErrorIs(t, errs[0], mongo.ErrNoDocuments)
+		assert.Equal(t, &domain.DuplicateSkuError{Sku: "ALFM25"}, errs[1])
+	})
+}
+
+func TestExport(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("sorts on disk when needed", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch,
+			bson.D{{Key: "sku", Value: "ALFM25"}},
+			bson.D{{Key: "sku", Value: "ALFM50"}},
+		))
+
+		var skus []string
+		err := repo.Export(context.Background(), domain.VoucherFilter{}, func(voucher *domain.Voucher) error {
+			skus = append(skus, voucher.Sku)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ALFM25", "ALFM50"}, skus)
+		assert.Equal(t, true, mt.GetStartedEvent().Command.Lookup("allowDiskUse").Boolean())
+	})
+}
+
+func TestRestore(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+
+	mt.Run("restores an archived voucher", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(bson.M{"_id": id, "sku": "ALFM25"}))
+
+		voucher, err := repo.Restore(context.Background(), id)
+		assert.NoError(t, err)
+		assert.Equal(t, id, voucher.Id)
+		assert.Nil(t, voucher.DeletedAt)
+	})
+
+	mt.Run("rejects a voucher that is not archived", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(nil), mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}}))
+
+		_, err := repo.Restore(context.Background(), id)
+		assert.ErrorIs(t, err, domain.ErrVoucherNotArchived)
+	})
+
+	mt.Run("reports a missing voucher", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(value(nil), mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch))
+
+		_, err := repo.Restore(context.Background(), id)
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+}
+
+func TestReserve(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+
+	mt.Run("takes the stock even when history fails", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(bson.M{"_id": id, "stock": 5, "product_status": domain.ProductStatusAvailable}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "history unavailable"}),
+		)
+
+		reservation, err := repo.Reserve(context.Background(), domain.Actor{}, id, 2, time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, id, reservation.VoucherId)
+		assert.Equal(t, 2, reservation.Quantity)
+		assert.Equal(t, domain.ReservationActive, reservation.Status)
+	})
+
+	mt.Run("reports the available stock", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(nil),
+			mtest.CreateCursorResponse(0, "db.vouchers", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "stock", Value: 1}}),
+		)
+
+		_, err := repo.Reserve(context.Background(), domain.Actor{}, id, 2, time.Now().Add(time.Minute))
+		assert.Equal(t, &domain.InsufficientStockError{Requested: 2, Available: 1}, err)
+	})
+}
+
+func TestRelease(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id, reservationId := primitive.NewObjectID(), primitive.NewObjectID()
+
+	mt.Run("returns the stock", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(bson.M{"_id": reservationId, "voucher_id": id, "quantity": 3, "status": domain.ReservationReleased}),
+			value(bson.M{"_id": id, "stock": 0, "product_status": domain.ProductStatusOutOfStock}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+		)
+
+		reservation, err := repo.Release(context.Background(), domain.Actor{}, id, reservationId, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ReservationReleased, reservation.Status)
+		assert.Equal(t, "commitTransaction", mt.GetAllStartedEvents()[3].CommandName)
+	})
+
+	mt.Run("reports a closed reservation", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(nil),
+			mtest.CreateCursorResponse(0, "db.reservations", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: reservationId},
+				{Key: "voucher_id", Value: id},
+				{Key: "status", Value: domain.ReservationExpired},
+			}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		_, err := repo.Release(context.Background(), domain.Actor{}, id, reservationId, 0)
+		assert.ErrorIs(t, err, domain.ErrReservationClosed)
+	})
+}
+
+func TestReleaseExpired(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	id := primitive.NewObjectID()
+	expired := bson.M{"_id": primitive.NewObjectID(), "voucher_id": id, "quantity": 2, "status": domain.ReservationExpired}
+
+	mt.Run("returns the stock of every expired reservation", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(expired),
+			value(bson.M{"_id": id, "stock": 1, "product_status": domain.ProductStatusAvailable}),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(),
+			value(nil),
+			mtest.CreateSuccessResponse(),
+		)
+
+		released, err := repo.ReleaseExpired(context.Background(), domain.Actor{}, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, released)
+	})
+
+	mt.Run("aborts the claim when the stock cannot be returned", func(mt *mtest.T) {
+		repo := NewMongoRepository(mt.DB, nil, false, &logger)
+		mt.AddMockResponses(
+			value(expired),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "stock update failed"}),
+			mtest.CreateSuccessResponse(),
+		)
+
+		released, err := repo.ReleaseExpired(context.Background(), domain.Actor{}, time.Now())
+		assert.Error(t, err)
+		assert.Equal(t, 0, released)
+		assert.Equal(t, "abortTransaction", mt.GetAllStartedEvents()[2].CommandName)
+	})
+}
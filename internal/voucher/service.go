@@ -1,46 +1,353 @@
 package voucher
 
 import (
+	"context"
+	"encoding/json"
 	"go-multiple-query/internal/domain"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type voucherService struct {
-	voucherRepo domain.VoucherRepository
+	voucherRepo    domain.VoucherRepository
+	reservationTTL time.Duration
 }
 
-// Count implements domain.VoucherUsecase.
-func (v *voucherService) Count(filter domain.VoucherFilter) (int64, error) {
-	count, err := v.voucherRepo.Count(filter)
+// FindWithFilter implements domain.VoucherUsecase.
+func (v *voucherService) FindWithFilter(ctx context.Context, filter domain.VoucherFilter) (*domain.VoucherPage, error) {
+	page, err := v.voucherRepo.FindWithFilter(ctx, filter)
+	if err == mongo.ErrNoDocuments {
+		return &domain.VoucherPage{}, domain.NotFound("Vouchers not found")
+	}
 	if err != nil {
-		return 0, err
+		return &domain.VoucherPage{}, domainError(err)
 	}
 
-	return count, err
+	return page, err
 }
 
-// FindWithFilter implements domain.VoucherUsecase.
-func (v *voucherService) FindWithFilter(filter domain.VoucherFilter) ([]*domain.Voucher, int, error) {
-	vouchers, nextCursor, err := v.voucherRepo.FindWithFilter(filter)
+// Export implements domain.VoucherUsecase.
+func (v *voucherService) Export(ctx context.Context, filter domain.VoucherFilter, each func(*domain.Voucher) error) error {
+	return domainError(v.voucherRepo.Export(ctx, filter, each))
+}
+
+// Aggregate implements domain.VoucherUsecase.
+func (v *voucherService) Aggregate(ctx context.Context, filter domain.VoucherFilter, groupBy string) ([]*domain.VoucherStats, error) {
+	stats, err := v.voucherRepo.Aggregate(ctx, filter, groupBy)
 	if err != nil {
-		return []*domain.Voucher{}, 0, err
+		return []*domain.VoucherStats{}, domainError(err)
 	}
 
-	return vouchers, nextCursor, err
+	return stats, err
 }
 
 // Store implements domain.VoucherUsecase.
-func (v *voucherService) Store(voucher *domain.Voucher) (*domain.Voucher, error) {
-	voucher, err := v.voucherRepo.Store(voucher)
+func (v *voucherService) Store(ctx context.Context, actor domain.Actor, voucher *domain.Voucher) (*domain.Voucher, error) {
+	voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+
+	voucher, err := v.voucherRepo.Store(ctx, actor, voucher)
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	return voucher, err
+}
+
+// StoreMany implements domain.VoucherUsecase.
+func (v *voucherService) StoreMany(ctx context.Context, actor domain.Actor, vouchers []*domain.Voucher, ordered bool) ([]error, error) {
+	if len(vouchers) == 0 {
+		return []error{}, nil
+	}
+
+	for _, voucher := range vouchers {
+		voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+	}
+
+	errs, err := v.voucherRepo.StoreMany(ctx, actor, vouchers, ordered)
+	if err != nil {
+		return nil, domainError(err)
+	}
+
+	return errs, err
+}
+
+// Import implements domain.VoucherUsecase. Rows are matched to the stored
+// vouchers by SKU, and by vendor when the row sets one. Matched vouchers get
+// the fields the row sets, rows without a match create a voucher. The
+// changes are written in one batch of updates and one of inserts, and rows
+// that could not be written are reported as failed, so the report is always
+// returned.
+func (v *voucherService) Import(ctx context.Context, actor domain.Actor, rows []*domain.VoucherImportRow, dryRun bool) (*domain.VoucherImportReport, error) {
+	report := &domain.VoucherImportReport{
+		DryRun:  dryRun,
+		Total:   len(rows),
+		Results: make([]*domain.VoucherImportResult, 0, len(rows)),
+	}
+
+	var skus []string
+	for _, row := range rows {
+		if row.Request.Sku != "" {
+			skus = append(skus, row.Request.Sku)
+		}
+	}
+	existing := []*domain.Voucher{}
+	var findErr error
+	if len(skus) > 0 {
+		existing, findErr = v.voucherRepo.FindBySkus(ctx, skus)
+	}
+
+	var created, updated []*domain.VoucherImportResult
+	var vouchers []*domain.Voucher
+	var updates []*domain.VoucherUpdate
+	seen := map[[2]string]bool{}
+	for _, row := range rows {
+		result := &domain.VoucherImportResult{Line: row.Line, Sku: row.Request.Sku}
+		report.Results = append(report.Results, result)
+
+		invalid := func(errors ...string) {
+			result.Status, result.Errors = domain.ImportStatusInvalid, errors
+		}
+
+		key := [2]string{row.Request.Sku, row.Request.Vendor}
+		switch {
+		case row.Request.Sku == "" || len(row.Errors) > 0:
+			result.Status, result.FieldErrors = domain.ImportStatusInvalid, row.Errors
+			continue
+		case seen[key]:
+			invalid("Sku appears more than once in the price list")
+			continue
+		case findErr != nil:
+			result.Status, result.Errors = domain.ImportStatusFailed, []string{rowErrorMessage(findErr)}
+			continue
+		}
+		seen[key] = true
+
+		match, err := matchImportRow(existing, row)
+		if err != nil {
+			invalid(err.Error())
+			continue
+		}
+
+		if match == nil {
+			if len(row.CreateErrors) > 0 {
+				result.Status, result.FieldErrors = domain.ImportStatusInvalid, row.CreateErrors
+				continue
+			}
+			result.Status = domain.ImportStatusNew
+			voucher := voucherFromRequest(&row.Request)
+			voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+			result.Changes = createdChanges(requestFromVoucher(&voucher))
+			vouchers = append(vouchers, &voucher)
+			created = append(created, result)
+			continue
+		}
+
+		id := match.Id
+		result.Id = &id
+
+		current := requestFromVoucher(match)
+		request := current
+		applyRequestFields(&request, &row.Request, row.Fields)
+		if !current.ProductStatus.CanTransitionTo(request.ProductStatus) {
+			invalid((&domain.InvalidStatusTransitionError{From: current.ProductStatus, To: request.ProductStatus}).Error())
+			continue
+		}
+		request.ProductStatus = request.ProductStatus.ForStock(request.Stock)
+
+		result.Changes = diffRequests(current, request)
+		if len(result.Changes) == 0 {
+			result.Status = domain.ImportStatusUnchanged
+			continue
+		}
+
+		result.Status = domain.ImportStatusChanged
+		voucher := voucherFromRequest(&row.Request)
+		updates = append(updates, &domain.VoucherUpdate{Id: id, Voucher: &voucher, Fields: row.Fields, Before: match})
+		updated = append(updated, result)
+	}
+
+	if !dryRun && len(updates) > 0 {
+		errs, err := v.voucherRepo.UpdateMany(ctx, actor, updates)
+		for i, result := range updated {
+			rowErr := err
+			if rowErr == nil {
+				rowErr = errs[i]
+			}
+			if rowErr != nil {
+				result.Status, result.Errors = domain.ImportStatusFailed, []string{rowErrorMessage(rowErr)}
+			}
+		}
+	}
+
+	if !dryRun && len(vouchers) > 0 {
+		errs, err := v.voucherRepo.StoreMany(ctx, actor, vouchers, false)
+		for i, result := range created {
+			rowErr := err
+			if rowErr == nil {
+				rowErr = errs[i]
+			}
+			if rowErr != nil {
+				result.Status, result.Errors = domain.ImportStatusFailed, []string{rowErrorMessage(rowErr)}
+				continue
+			}
+			result.Id = &vouchers[i].Id
+		}
+	}
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case domain.ImportStatusNew:
+			report.New++
+		case domain.ImportStatusChanged:
+			report.Changed++
+		case domain.ImportStatusUnchanged:
+			report.Unchanged++
+		case domain.ImportStatusInvalid:
+			report.Invalid++
+		case domain.ImportStatusFailed:
+			report.Failed++
+		}
+	}
+
+	return report, nil
+}
+
+// FindByID implements domain.VoucherUsecase.
+func (v *voucherService) FindByID(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error) {
+	voucher, err := v.voucherRepo.FindByID(ctx, id)
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	return voucher, err
+}
+
+// Update implements domain.VoucherUsecase. A changed product status must be
+// a valid transition from the stored one, checked as part of the write. The
+// stock is replaced only while it is still the stock read here, so stock
+// reserved or released in the meantime is not overwritten.
+func (v *voucherService) Update(ctx context.Context, actor domain.Actor, id primitive.ObjectID, voucher *domain.Voucher) (*domain.Voucher, error) {
+	current, err := v.voucherRepo.FindByID(ctx, id)
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+	voucher.ProductStatus = voucher.ProductStatus.ForStock(voucher.Stock)
+
+	voucher, err = v.voucherRepo.Update(ctx, actor, &domain.VoucherUpdate{
+		Id:      id,
+		Voucher: voucher,
+		Fields:  requestFields,
+		IfStock: &current.Stock,
+	})
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	return voucher, err
+}
+
+// Patch implements domain.VoucherUsecase. Only the fields in the patch are
+// written, following JSON Merge Patch, where null removes a field. A patched
+// product status must be a valid transition from the stored one.
+func (v *voucherService) Patch(ctx context.Context, actor domain.Actor, id primitive.ObjectID, patch map[string]json.RawMessage) (*domain.Voucher, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	// Removed fields are left at their zero value
+	var patched domain.Voucher
+	if err := json.Unmarshal(body, &patched); err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	fields := make([]string, 0, len(patch))
+	for field := range patch {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	voucher, err := v.voucherRepo.Update(ctx, actor, &domain.VoucherUpdate{Id: id, Voucher: &patched, Fields: fields})
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	return voucher, err
+}
+
+// Delete implements domain.VoucherUsecase.
+func (v *voucherService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return domainError(v.voucherRepo.Delete(ctx, id))
+}
+
+// Restore implements domain.VoucherUsecase.
+func (v *voucherService) Restore(ctx context.Context, id primitive.ObjectID) (*domain.Voucher, error) {
+	voucher, err := v.voucherRepo.Restore(ctx, id)
 	if err != nil {
-		return &domain.Voucher{}, err
+		return &domain.Voucher{}, domainError(err)
 	}
 
 	return voucher, err
 }
 
-// NewVoucherService creates a new instance of VoucherService.
-func NewVoucherService(voucherRepo domain.VoucherRepository) domain.VoucherService {
+// Reserve implements domain.VoucherUsecase.
+func (v *voucherService) Reserve(ctx context.Context, actor domain.Actor, id primitive.ObjectID, quantity int) (*domain.Reservation, error) {
+	reservation, err := v.voucherRepo.Reserve(ctx, actor, id, quantity, time.Now().Add(v.reservationTTL))
+	if err != nil {
+		return &domain.Reservation{}, domainError(err)
+	}
+
+	return reservation, err
+}
+
+// Release implements domain.VoucherUsecase.
+func (v *voucherService) Release(ctx context.Context, actor domain.Actor, id, reservationId primitive.ObjectID, quantity int) (*domain.Reservation, error) {
+	reservation, err := v.voucherRepo.Release(ctx, actor, id, reservationId, quantity)
+	if err != nil {
+		return &domain.Reservation{}, domainError(err)
+	}
+
+	return reservation, err
+}
+
+// ReleaseExpiredReservations implements domain.VoucherUsecase. The released
+// stock is recorded as changed by the system.
+func (v *voucherService) ReleaseExpiredReservations(ctx context.Context) (int, error) {
+	return v.voucherRepo.ReleaseExpired(ctx, domain.Actor{Name: domain.SystemActor}, time.Now())
+}
+
+// ChangeStatus implements domain.VoucherUsecase.
+func (v *voucherService) ChangeStatus(ctx context.Context, actor domain.Actor, id primitive.ObjectID, status domain.ProductStatus) (*domain.Voucher, error) {
+	voucher, err := v.voucherRepo.UpdateStatus(ctx, actor, id, status)
+	if err != nil {
+		return &domain.Voucher{}, domainError(err)
+	}
+
+	return voucher, err
+}
+
+// FindHistory implements domain.VoucherUsecase.
+func (v *voucherService) FindHistory(ctx context.Context, id primitive.ObjectID, page, size int) (*domain.VoucherHistoryPage, error) {
+	if _, err := v.voucherRepo.FindByID(ctx, id); err != nil {
+		return &domain.VoucherHistoryPage{}, domainError(err)
+	}
+
+	history, err := v.voucherRepo.FindHistory(ctx, id, page, size)
+	if err != nil {
+		return &domain.VoucherHistoryPage{}, domainError(err)
+	}
+
+	return history, err
+}
+
+// NewVoucherService creates a new instance of VoucherService. Stock
+// reservations expire after reservationTTL unless released before.
+func NewVoucherService(voucherRepo domain.VoucherRepository, reservationTTL time.Duration) domain.VoucherService {
 	return &voucherService{
-		voucherRepo: voucherRepo,
+		voucherRepo:    voucherRepo,
+		reservationTTL: reservationTTL,
 	}
 }